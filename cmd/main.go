@@ -2,16 +2,23 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"fmt"
 	"log"
+	"log/slog"
 	"os"
 	"os/signal"
-	"strings"
 	"syscall"
+	"time"
 
 	"livechat-ws/internal/config"
 	"livechat-ws/internal/delivery"
+	"livechat-ws/internal/infrastructure/auth"
+	"livechat-ws/internal/infrastructure/broker"
 	"livechat-ws/internal/infrastructure/kafka"
+	"livechat-ws/internal/infrastructure/logging"
 	"livechat-ws/internal/infrastructure/redis"
+	"livechat-ws/internal/infrastructure/tracing"
 
 	"github.com/joho/godotenv"
 )
@@ -30,12 +37,54 @@ func main() {
 	// Load configuration
 	cfg := config.LoadConfig()
 
-	log.Printf("Starting LiveChat WebSocket Server")
-	log.Printf("Environment: %s", cfg.Environment)
-	log.Printf("Port: %s", cfg.Port)
-	log.Printf("Redis: %s:%s", cfg.RedisHost, cfg.RedisPort)
-	log.Printf("Kafka Brokers: %v", cfg.KafkaBrokers)
-	log.Printf("CORS Origins: %s", cfg.GetCORSOrigins())
+	// Logger aplikasi, juga dipasang sebagai default supaya output package log ikut terstruktur
+	logger, err := logging.New(os.Stdout, logging.Options{
+		Level:  cfg.LogLevel,
+		Format: cfg.LogFormat,
+		Redact: cfg.LogRedactPII,
+	})
+	if err != nil {
+		log.Fatalf("Invalid logging configuration: %v", err)
+	}
+	logger = logger.With("instance_id", cfg.InstanceID)
+	slog.SetDefault(logger)
+
+	fatal := func(msg string, args ...any) {
+		logger.Error(msg, args...)
+		os.Exit(1)
+	}
+
+	// Tracing dipasang sebelum komponen lain supaya producer, consumer, dan hook Redis
+	// memakai tracer provider yang sama
+	shutdownTracing, err := tracing.Setup(context.Background(), tracing.Options{
+		Exporter:     cfg.TracingExporter,
+		OTLPEndpoint: cfg.TracingOTLPEndpoint,
+		OTLPInsecure: cfg.TracingOTLPInsecure,
+		ServiceName:  cfg.TracingServiceName,
+		InstanceID:   cfg.InstanceID,
+		SampleRatio:  cfg.TracingSampleRatio,
+	})
+	if err != nil {
+		fatal("Invalid tracing configuration", "error", err)
+	}
+
+	logger.Info("Starting LiveChat WebSocket Server",
+		"environment", cfg.Environment,
+		"port", cfg.Port,
+		"redis", cfg.RedisHost+":"+cfg.RedisPort,
+		"broker", cfg.BrokerType,
+		"cors_origins", cfg.GetCORSOrigins())
+	if cfg.BrokerType == broker.TypeKafka {
+		logger.Info("Kafka configuration",
+			"brokers", cfg.KafkaBrokers,
+			"consumer_mode", cfg.KafkaConsumerMode,
+			"group_id", cfg.GetKafkaConsumerGroupID(),
+			"tls", cfg.KafkaTLSEnabled,
+			"sasl", valueOrNone(cfg.KafkaSASLMechanism))
+	}
+	if cfg.TracingExporter == tracing.ExporterOTLP {
+		logger.Info("Tracing enabled", "endpoint", cfg.TracingOTLPEndpoint, "sample_ratio", cfg.TracingSampleRatio)
+	}
 
 	// Initialize components
 	redisClient := redis.NewRedisClient(cfg.RedisHost, cfg.RedisPort, cfg.RedisPassword)
@@ -43,27 +92,101 @@ func main() {
 	// Test Redis connection
 	ctx := context.Background()
 	if err := redisClient.Ping(ctx); err != nil {
-		log.Printf("Warning: Redis connection failed: %v", err)
+		logger.Warn("Redis connection failed", "error", err)
 	} else {
-		log.Println("Redis connection successful")
+		logger.Info("Redis connection successful")
+	}
+
+	// Setup event broker sesuai konfigurasi
+	if cfg.EventFormat != broker.FormatEnvelope && cfg.EventFormat != broker.FormatLegacy && cfg.EventFormat != broker.FormatCloudEvents {
+		fatal("Unknown event format", "event_format", cfg.EventFormat)
+	}
+	if cfg.CloudEventsMode != broker.CloudEventsStructured && cfg.CloudEventsMode != broker.CloudEventsBinary {
+		fatal("Unknown CLOUDEVENTS_MODE", "cloudevents_mode", cfg.CloudEventsMode)
+	}
+	codec := broker.Codec{
+		Format:            cfg.EventFormat,
+		CloudEventsMode:   cfg.CloudEventsMode,
+		CloudEventsSource: cfg.CloudEventsSource,
+	}
+	if cfg.UnknownEventPolicy != config.UnknownEventDrop && cfg.UnknownEventPolicy != config.UnknownEventPassthrough {
+		fatal("Unknown UNKNOWN_EVENT_POLICY", "unknown_event_policy", cfg.UnknownEventPolicy)
+	}
+
+	// Heartbeat: time.NewTicker panic untuk interval <= 0, dan pong wait yang tidak lebih
+	// lama dari ping interval memutus client yang sehat
+	if cfg.WSPingInterval <= 0 {
+		fatal("WS_PING_INTERVAL must be positive", "ws_ping_interval", cfg.WSPingInterval)
+	}
+	if cfg.WSPongWait <= cfg.WSPingInterval {
+		fatal("WS_PONG_WAIT must be longer than WS_PING_INTERVAL", "ws_pong_wait", cfg.WSPongWait, "ws_ping_interval", cfg.WSPingInterval)
+	}
+	if cfg.WSSendQueueSize <= 0 {
+		fatal("WS_SEND_QUEUE_SIZE must be positive", "ws_send_queue_size", cfg.WSSendQueueSize)
+	}
+	switch cfg.WSSlowConsumerPolicy {
+	case delivery.SlowConsumerDropOldest, delivery.SlowConsumerDropTyping, delivery.SlowConsumerDisconnect:
+	default:
+		fatal("Unknown WS_SLOW_CONSUMER_POLICY", "ws_slow_consumer_policy", cfg.WSSlowConsumerPolicy)
 	}
 
-	// Create WebSocket manager with producer
-	kafkaBroker := strings.Join(cfg.KafkaBrokers, ",")
-	kafkaProducer := kafka.NewKafkaProducer(kafkaBroker, "chat-messages")
-	wsManager := delivery.NewWSManager(kafkaProducer, redisClient)
+	// Registry instance: key instance harus hidup lebih lama dari jeda antar heartbeat
+	if cfg.InstanceHeartbeatInterval <= 0 {
+		fatal("INSTANCE_HEARTBEAT_INTERVAL must be positive", "instance_heartbeat_interval", cfg.InstanceHeartbeatInterval)
+	}
+	if cfg.InstanceTTL <= cfg.InstanceHeartbeatInterval {
+		fatal("INSTANCE_TTL must be longer than INSTANCE_HEARTBEAT_INTERVAL", "instance_ttl", cfg.InstanceTTL, "instance_heartbeat_interval", cfg.InstanceHeartbeatInterval)
+	}
 
-	// Setup Kafka consumer for multi-topic support
-	kafkaTopics := []string{"chat-messages", "typing-indicators", "connection-status"}
-	kafkaConsumer := kafka.NewKafkaConsumer(
-		cfg.KafkaBrokers,
-		"livechat-ws-group",
-		kafkaTopics,
-		wsManager,
-	)
+	// Event log session: Redis menolak EXPIRE/EX dalam detik yang bernilai 0
+	if cfg.SessionEventRetention < time.Second || cfg.SessionEventDedupTTL < time.Second {
+		fatal("SESSION_EVENT_RETENTION and SESSION_EVENT_DEDUP_TTL must be at least 1s",
+			"session_event_retention", cfg.SessionEventRetention, "session_event_dedup_ttl", cfg.SessionEventDedupTTL)
+	}
+	var eventBroker broker.Broker
+	var deadLetters *kafka.DeadLetterQueue
+	switch cfg.BrokerType {
+	case broker.TypeKafka:
+		if cfg.KafkaConsumerMode != config.KafkaConsumerBroadcast && cfg.KafkaConsumerMode != config.KafkaConsumerShared {
+			fatal("Unknown KAFKA_CONSUMER_MODE", "kafka_consumer_mode", cfg.KafkaConsumerMode)
+		}
+		kafkaConfig, err := newKafkaConfig(cfg, codec)
+		if err != nil {
+			fatal("Invalid Kafka configuration", "error", err)
+		}
+		kafkaConfig.Logger = logger
+		kafkaBroker := kafka.NewKafkaBroker(kafkaConfig, cfg.InstanceID)
+		if cfg.KafkaValidateTopics {
+			validateCtx, cancelValidate := context.WithTimeout(ctx, cfg.KafkaDialTimeout)
+			if err := kafkaBroker.ValidateTopics(validateCtx); err != nil {
+				fatal("Kafka topic validation failed", "error", err)
+			}
+			cancelValidate()
+		}
+		deadLetters = kafkaBroker.DeadLetters()
+		eventBroker = kafkaBroker
+	case broker.TypeRedis:
+		eventBroker = redis.NewRedisBroker(redisClient, cfg.InstanceID, codec, logger)
+	case broker.TypeMemory:
+		eventBroker = broker.NewMemoryBroker(cfg.InstanceID, logger)
+	default:
+		fatal("Unknown broker type", "broker", cfg.BrokerType)
+	}
+
+	// Create WebSocket manager with broker
+	wsManager := delivery.NewWSManager(cfg, eventBroker, redisClient, logger)
+
+	// Setup JWT validator for WebSocket authentication
+	jwtValidator, err := auth.NewJWTValidator(cfg.JWTSecret, cfg.JWTJWKSFile, cfg.JWTIssuer, cfg.JWTAudience)
+	if err != nil {
+		if cfg.WSAuthRequired {
+			fatal("Failed to setup JWT authentication", "error", err)
+		}
+		logger.Warn("JWT authentication disabled", "error", err)
+	}
 
 	// Create server with configuration
-	server := delivery.NewServer(cfg, kafkaConsumer, redisClient, wsManager)
+	server := delivery.NewServer(cfg, eventBroker, deadLetters, redisClient, wsManager, jwtValidator, logger)
 
 	// Setup graceful shutdown
 	ctx, cancel := context.WithCancel(context.Background())
@@ -73,36 +196,125 @@ func main() {
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 
+	logger.Info("Starting subscriber and WebSocket server", "broker", cfg.BrokerType)
+
+	// Subscribe to all event topics in background, event diteruskan lewat registry per tipe
+	registry := broker.NewRegistry(logger)
+	wsManager.RegisterEventHandlers(registry)
+	if err := eventBroker.Subscribe(ctx, broker.DefaultTopics, registry); err != nil {
+		logger.Error("Broker subscribe error", "error", err)
+	}
+
+	// Daftarkan instance ke Redis untuk agregat cluster di admin API
+	heartbeatDone := make(chan struct{})
 	go func() {
-		<-sigChan
-		log.Println("Shutting down...")
-		cancel()
-		if err := kafkaConsumer.Close(); err != nil {
-			log.Printf("Error closing Kafka consumer: %v", err)
-		}
-		if err := kafkaProducer.Close(); err != nil {
-			log.Printf("Error closing Kafka producer: %v", err)
-		}
-		if err := redisClient.Close(); err != nil {
-			log.Printf("Error closing Redis client: %v", err)
+		defer close(heartbeatDone)
+		wsManager.RunInstanceHeartbeat(ctx)
+	}()
+
+	// Start server in background
+	go func() {
+		if err := server.Start(); err != nil {
+			fatal("Server error", "error", err)
 		}
 	}()
 
-	log.Printf("Starting Kafka consumer and WebSocket server...")
+	<-sigChan
+	logger.Info("Shutting down")
 
-	// Start Kafka consumer in background
-	go func() {
-		defer func() {
-			if r := recover(); r != nil {
-				log.Printf("Kafka consumer goroutine recovered from panic: %v", r)
-			}
-		}()
+	// Urutan shutdown: tutup WebSocket (presence cleanup masih butuh Redis dan broker),
+	// matikan Fiber, baru tutup broker dan Redis
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancelShutdown()
+
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		logger.Error("Error shutting down server", "error", err)
+	}
 
-		if err := kafkaConsumer.Start(ctx); err != nil {
-			log.Printf("Kafka consumer error: %v", err)
+	cancel()
+	<-heartbeatDone
+	if err := eventBroker.Close(); err != nil {
+		logger.Error("Error closing broker", "error", err)
+	}
+	if err := redisClient.Close(); err != nil {
+		logger.Error("Error closing Redis client", "error", err)
+	}
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		logger.Error("Error flushing traces", "error", err)
+	}
+
+	logger.Info("Shutdown complete")
+}
+
+// newKafkaConfig menerjemahkan konfigurasi aplikasi ke konfigurasi package kafka
+func newKafkaConfig(cfg *config.Config, codec broker.Codec) (kafka.Config, error) {
+	startOffset, err := kafka.ParseStartOffset(cfg.KafkaStartOffset)
+	if err != nil {
+		return kafka.Config{}, fmt.Errorf("KAFKA_START_OFFSET: %w", err)
+	}
+	requiredAcks, err := kafka.ParseRequiredAcks(cfg.KafkaRequiredAcks)
+	if err != nil {
+		return kafka.Config{}, fmt.Errorf("KAFKA_REQUIRED_ACKS: %w", err)
+	}
+	compression, err := kafka.ParseCompression(cfg.KafkaCompression)
+	if err != nil {
+		return kafka.Config{}, fmt.Errorf("KAFKA_COMPRESSION: %w", err)
+	}
+
+	saslMechanism, err := kafka.NewSASLMechanism(cfg.KafkaSASLMechanism, cfg.KafkaSASLUsername, cfg.KafkaSASLPassword)
+	if err != nil {
+		return kafka.Config{}, fmt.Errorf("KAFKA_SASL_MECHANISM: %w", err)
+	}
+
+	var tlsConfig *tls.Config
+	if cfg.KafkaTLSEnabled {
+		tlsConfig, err = kafka.NewTLSConfig(kafka.TLSOptions{
+			CAFile:             cfg.KafkaTLSCAFile,
+			CertFile:           cfg.KafkaTLSCertFile,
+			KeyFile:            cfg.KafkaTLSKeyFile,
+			ServerName:         cfg.KafkaTLSServerName,
+			InsecureSkipVerify: cfg.KafkaTLSInsecureSkipVerify,
+		})
+		if err != nil {
+			return kafka.Config{}, fmt.Errorf("Kafka TLS: %w", err)
 		}
-	}()
+	}
 
-	// Start server (blocking)
-	log.Fatal(server.Start())
+	return kafka.Config{
+		Brokers:     cfg.KafkaBrokers,
+		Codec:       codec,
+		DialTimeout: cfg.KafkaDialTimeout,
+		TLS:         tlsConfig,
+		SASL:        saslMechanism,
+		Topics: kafka.TopicConfig{
+			Prefix:           cfg.KafkaTopicPrefix,
+			ChatMessages:     cfg.KafkaTopicChatMessages,
+			TypingIndicators: cfg.KafkaTopicTypingIndicators,
+			ConnectionStatus: cfg.KafkaTopicConnectionStatus,
+			DeadLetter:       cfg.KafkaDeadLetterTopic,
+		},
+		Producer: kafka.ProducerConfig{
+			RequiredAcks: requiredAcks,
+			BatchSize:    cfg.KafkaBatchSize,
+			BatchTimeout: cfg.KafkaBatchTimeout,
+			Compression:  compression,
+			WriteTimeout: cfg.KafkaWriteTimeout,
+			ReadTimeout:  cfg.KafkaReadTimeout,
+		},
+		Consumer: kafka.ConsumerConfig{
+			GroupID:        cfg.GetKafkaConsumerGroupID(),
+			StartOffset:    startOffset,
+			MaxRetries:     cfg.KafkaHandlerMaxRetries,
+			RetryBackoff:   cfg.KafkaRetryBackoff,
+			MaxWait:        cfg.KafkaMaxWait,
+			CommitInterval: cfg.KafkaCommitInterval,
+		},
+	}, nil
+}
+
+func valueOrNone(value string) string {
+	if value == "" {
+		return "none"
+	}
+	return value
 }
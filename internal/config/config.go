@@ -1,8 +1,11 @@
 package config
 
 import (
+	"fmt"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 )
 
 type Config struct {
@@ -14,8 +17,129 @@ type Config struct {
 	RedisPassword    string
 	KafkaBrokers     []string
 	Environment      string
+
+	// Logging: level debug/info/warn/error, format text/json. Isi pesan, attachment,
+	// dan payload event diredaksi kecuali LOG_REDACT_PII=false.
+	LogLevel     string
+	LogFormat    string
+	LogRedactPII bool
+
+	// Tracing OpenTelemetry: exporter none (no-op) atau otlp (OTLP/HTTP)
+	TracingExporter     string
+	TracingOTLPEndpoint string
+	TracingOTLPInsecure bool
+	TracingServiceName  string
+	TracingSampleRatio  float64
+
+	// WebSocket authentication
+	WSAuthRequired bool
+	JWTSecret      string
+	JWTJWKSFile    string
+	JWTIssuer      string
+	JWTAudience    string
+	WSTicketTTL    time.Duration
+
+	// WebSocket heartbeat
+	WSPingInterval time.Duration
+	WSPongWait     time.Duration
+
+	// Outbound queue per WebSocket connection
+	WSSendQueueSize      int
+	WSSlowConsumerPolicy string
+
+	// TTL untuk de-duplikasi client_message_id pada send_message
+	MessageDedupTTL time.Duration
+
+	// Event log per session untuk resume setelah reconnect
+	SessionEventLogSize   int
+	SessionEventRetention time.Duration
+	// Berapa lama event ID -> seq diingat supaya event yang sama dari broker mendapat
+	// seq yang sama di semua instance. Harus lebih lama dari lag consumer antar instance.
+	SessionEventDedupTTL time.Duration
+
+	// Graceful shutdown
+	ShutdownTimeout time.Duration
+	WSReconnectHint time.Duration
+
+	// Batas waktu setiap pemeriksaan dependency pada /readyz
+	HealthCheckTimeout time.Duration
+
+	// Broker event: kafka, redis, atau memory
+	BrokerType string
+	// Format event yang di-publish: envelope, legacy, atau cloudevents
+	EventFormat string
+	// Mode CloudEvents (structured atau binary) dan atribut source-nya
+	CloudEventsMode   string
+	CloudEventsSource string
+	// Perlakuan event dengan tipe yang tidak punya handler: drop atau passthrough
+	UnknownEventPolicy string
+
+	// Multi-instance
+	InstanceID        string
+	KafkaGroupID      string
+	KafkaConsumerMode string
+
+	// Registry instance di Redis untuk agregat cluster di admin API
+	InstanceHeartbeatInterval time.Duration
+	InstanceTTL               time.Duration
+
+	// Konsumsi Kafka at-least-once
+	KafkaStartOffset       string
+	KafkaHandlerMaxRetries int
+	KafkaRetryBackoff      time.Duration
+	KafkaDeadLetterTopic   string
+
+	// Nama topic Kafka, prefix ditambahkan ke semua topic (termasuk DLQ)
+	KafkaTopicPrefix           string
+	KafkaTopicChatMessages     string
+	KafkaTopicTypingIndicators string
+	KafkaTopicConnectionStatus string
+	KafkaValidateTopics        bool
+
+	// Tuning producer dan consumer Kafka
+	KafkaRequiredAcks   string
+	KafkaBatchSize      int
+	KafkaBatchTimeout   time.Duration
+	KafkaCompression    string
+	KafkaWriteTimeout   time.Duration
+	KafkaReadTimeout    time.Duration
+	KafkaDialTimeout    time.Duration
+	KafkaMaxWait        time.Duration
+	KafkaCommitInterval time.Duration
+
+	// Keamanan koneksi Kafka
+	KafkaTLSEnabled            bool
+	KafkaTLSCAFile             string
+	KafkaTLSCertFile           string
+	KafkaTLSKeyFile            string
+	KafkaTLSServerName         string
+	KafkaTLSInsecureSkipVerify bool
+	KafkaSASLMechanism         string
+	KafkaSASLUsername          string
+	KafkaSASLPassword          string
+
+	// Token untuk endpoint /api/admin, kosong berarti admin API nonaktif
+	AdminAPIToken string
 }
 
+// Mode consumer Kafka
+const (
+	// KafkaConsumerBroadcast: setiap instance memakai consumer group sendiri sehingga
+	// semua instance menerima semua event (fan-out ke client di tiap instance)
+	KafkaConsumerBroadcast = "broadcast"
+	// KafkaConsumerShared: semua instance berbagi satu consumer group, setiap event
+	// hanya diterima oleh satu instance
+	KafkaConsumerShared = "shared"
+)
+
+// Perlakuan event dengan tipe yang tidak dikenal
+const (
+	// UnknownEventDrop: event di-log lalu dibuang
+	UnknownEventDrop = "drop"
+	// UnknownEventPassthrough: event diteruskan ke client session dengan type dan payload apa adanya
+	UnknownEventPassthrough = "passthrough"
+)
+
 func LoadConfig() *Config {
 	// Get allowed origins from environment variable
 	allowedOrigins := []string{"*"} // Default to allow all origins
@@ -45,6 +169,83 @@ func LoadConfig() *Config {
 		RedisPassword:    getEnv("REDIS_PASSWORD", ""),
 		KafkaBrokers:     kafkaBrokers,
 		Environment:      getEnv("ENVIRONMENT", "development"),
+		LogLevel:         getEnv("LOG_LEVEL", "info"),
+		LogFormat:        getEnv("LOG_FORMAT", "text"),
+		LogRedactPII:     getEnv("LOG_REDACT_PII", "true") == "true",
+		WSAuthRequired:   getEnv("WS_AUTH_REQUIRED", "true") == "true",
+		JWTSecret:        getEnv("JWT_SECRET", ""),
+		JWTJWKSFile:      getEnv("JWT_JWKS_FILE", ""),
+		JWTIssuer:        getEnv("JWT_ISSUER", ""),
+		JWTAudience:      getEnv("JWT_AUDIENCE", ""),
+		WSTicketTTL:      getDurationEnv("WS_TICKET_TTL", 30*time.Second),
+		WSPingInterval:   getDurationEnv("WS_PING_INTERVAL", 30*time.Second),
+		WSPongWait:       getDurationEnv("WS_PONG_WAIT", 60*time.Second),
+
+		TracingExporter:     getEnv("TRACING_EXPORTER", "none"),
+		TracingOTLPEndpoint: getEnv("OTEL_EXPORTER_OTLP_ENDPOINT", "localhost:4318"),
+		TracingOTLPInsecure: getEnv("OTEL_EXPORTER_OTLP_INSECURE", "false") == "true",
+		TracingServiceName:  getEnv("OTEL_SERVICE_NAME", "livechat-ws"),
+		TracingSampleRatio:  getFloatEnv("TRACING_SAMPLE_RATIO", 1.0),
+
+		WSSendQueueSize:      getIntEnv("WS_SEND_QUEUE_SIZE", 256),
+		WSSlowConsumerPolicy: getEnv("WS_SLOW_CONSUMER_POLICY", "drop_oldest"),
+
+		MessageDedupTTL: getDurationEnv("MESSAGE_DEDUP_TTL", 24*time.Hour),
+
+		SessionEventLogSize:   getIntEnv("SESSION_EVENT_LOG_SIZE", 1000),
+		SessionEventRetention: getDurationEnv("SESSION_EVENT_RETENTION", 24*time.Hour),
+		SessionEventDedupTTL:  getDurationEnv("SESSION_EVENT_DEDUP_TTL", 10*time.Minute),
+
+		ShutdownTimeout: getDurationEnv("SHUTDOWN_TIMEOUT", 15*time.Second),
+		WSReconnectHint: getDurationEnv("WS_RECONNECT_HINT", 2*time.Second),
+
+		HealthCheckTimeout: getDurationEnv("HEALTH_CHECK_TIMEOUT", 2*time.Second),
+
+		BrokerType:         getEnv("BROKER_TYPE", "kafka"),
+		EventFormat:        getEnv("EVENT_FORMAT", "legacy"),
+		CloudEventsMode:    getEnv("CLOUDEVENTS_MODE", "structured"),
+		CloudEventsSource:  getEnv("CLOUDEVENTS_SOURCE", "/livechat-ws"),
+		UnknownEventPolicy: getEnv("UNKNOWN_EVENT_POLICY", UnknownEventDrop),
+
+		InstanceID:        getEnv("INSTANCE_ID", defaultInstanceID()),
+		KafkaGroupID:      getEnv("KAFKA_GROUP_ID", "livechat-ws-group"),
+		KafkaConsumerMode: getEnv("KAFKA_CONSUMER_MODE", KafkaConsumerBroadcast),
+
+		InstanceHeartbeatInterval: getDurationEnv("INSTANCE_HEARTBEAT_INTERVAL", 10*time.Second),
+		InstanceTTL:               getDurationEnv("INSTANCE_TTL", 30*time.Second),
+
+		KafkaStartOffset:       getEnv("KAFKA_START_OFFSET", "latest"),
+		KafkaHandlerMaxRetries: getIntEnv("KAFKA_HANDLER_MAX_RETRIES", 5),
+		KafkaRetryBackoff:      getDurationEnv("KAFKA_RETRY_BACKOFF", 200*time.Millisecond),
+		KafkaDeadLetterTopic:   lookupEnv("KAFKA_DLQ_TOPIC", "livechat-ws-dlq"),
+
+		KafkaTopicPrefix:           getEnv("KAFKA_TOPIC_PREFIX", ""),
+		KafkaTopicChatMessages:     getEnv("KAFKA_TOPIC_CHAT_MESSAGES", "chat-messages"),
+		KafkaTopicTypingIndicators: getEnv("KAFKA_TOPIC_TYPING_INDICATORS", "typing-indicators"),
+		KafkaTopicConnectionStatus: getEnv("KAFKA_TOPIC_CONNECTION_STATUS", "connection-status"),
+		KafkaValidateTopics:        getEnv("KAFKA_VALIDATE_TOPICS", "true") == "true",
+
+		KafkaRequiredAcks:   getEnv("KAFKA_REQUIRED_ACKS", "one"),
+		KafkaBatchSize:      getIntEnv("KAFKA_BATCH_SIZE", 1),
+		KafkaBatchTimeout:   getDurationEnv("KAFKA_BATCH_TIMEOUT", time.Millisecond),
+		KafkaCompression:    getEnv("KAFKA_COMPRESSION", "none"),
+		KafkaWriteTimeout:   getDurationEnv("KAFKA_WRITE_TIMEOUT", 10*time.Second),
+		KafkaReadTimeout:    getDurationEnv("KAFKA_READ_TIMEOUT", 10*time.Second),
+		KafkaDialTimeout:    getDurationEnv("KAFKA_DIAL_TIMEOUT", 10*time.Second),
+		KafkaMaxWait:        getDurationEnv("KAFKA_MAX_WAIT", 100*time.Millisecond),
+		KafkaCommitInterval: getDurationEnv("KAFKA_COMMIT_INTERVAL", 100*time.Millisecond),
+
+		KafkaTLSEnabled:            getEnv("KAFKA_TLS_ENABLED", "false") == "true",
+		KafkaTLSCAFile:             getEnv("KAFKA_TLS_CA_FILE", ""),
+		KafkaTLSCertFile:           getEnv("KAFKA_TLS_CERT_FILE", ""),
+		KafkaTLSKeyFile:            getEnv("KAFKA_TLS_KEY_FILE", ""),
+		KafkaTLSServerName:         getEnv("KAFKA_TLS_SERVER_NAME", ""),
+		KafkaTLSInsecureSkipVerify: getEnv("KAFKA_TLS_INSECURE_SKIP_VERIFY", "false") == "true",
+		KafkaSASLMechanism:         getEnv("KAFKA_SASL_MECHANISM", ""),
+		KafkaSASLUsername:          getEnv("KAFKA_SASL_USERNAME", ""),
+		KafkaSASLPassword:          getEnv("KAFKA_SASL_PASSWORD", ""),
+
+		AdminAPIToken: getEnv("ADMIN_API_TOKEN", ""),
 	}
 }
 
@@ -55,6 +256,62 @@ func getEnv(key, defaultValue string) string {
 	return defaultValue
 }
 
+// lookupEnv seperti getEnv, tapi nilai kosong yang di-set eksplisit tetap dipakai
+// (misalnya KAFKA_DLQ_TOPIC= untuk menonaktifkan DLQ)
+func lookupEnv(key, defaultValue string) string {
+	if value, ok := os.LookupEnv(key); ok {
+		return value
+	}
+	return defaultValue
+}
+
+func getIntEnv(key string, defaultValue int) int {
+	if value := os.Getenv(key); value != "" {
+		if number, err := strconv.Atoi(value); err == nil {
+			return number
+		}
+	}
+	return defaultValue
+}
+
+func getDurationEnv(key string, defaultValue time.Duration) time.Duration {
+	if value := os.Getenv(key); value != "" {
+		if duration, err := time.ParseDuration(value); err == nil {
+			return duration
+		}
+	}
+	return defaultValue
+}
+
+func getFloatEnv(key string, defaultValue float64) float64 {
+	if value := os.Getenv(key); value != "" {
+		if number, err := strconv.ParseFloat(value, 64); err == nil {
+			return number
+		}
+	}
+	return defaultValue
+}
+
+// defaultInstanceID memakai hostname (nama pod di Kubernetes). Tidak ditambah suffix
+// acak supaya restart memakai consumer group yang sama dalam mode broadcast.
+func defaultInstanceID() string {
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		return "livechat-ws"
+	}
+	return hostname
+}
+
+// GetKafkaConsumerGroupID returns the consumer group ID for this instance based on the consumer mode.
+// Group per instance di mode broadcast tidak pernah dihapus oleh service, sehingga INSTANCE_ID
+// yang berubah setiap restart meninggalkan consumer group yatim di Kafka.
+func (c *Config) GetKafkaConsumerGroupID() string {
+	if c.KafkaConsumerMode == KafkaConsumerShared {
+		return c.KafkaGroupID
+	}
+	return fmt.Sprintf("%s-%s", c.KafkaGroupID, c.InstanceID)
+}
+
 // GetCORSOrigins returns CORS origins as a comma-separated string
 func (c *Config) GetCORSOrigins() string {
 	if c.Environment == "production" && len(c.AllowedOrigins) > 0 && c.AllowedOrigins[0] != "*" {
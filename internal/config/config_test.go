@@ -0,0 +1,44 @@
+package config
+
+import "testing"
+
+func TestGetKafkaConsumerGroupID(t *testing.T) {
+	tests := []struct {
+		name string
+		mode string
+		want string
+	}{
+		// Mode broadcast: group per instance supaya setiap instance menerima semua event
+		{name: "broadcast", mode: KafkaConsumerBroadcast, want: "livechat-ws-group-ws-1"},
+		{name: "shared", mode: KafkaConsumerShared, want: "livechat-ws-group"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{KafkaGroupID: "livechat-ws-group", InstanceID: "ws-1", KafkaConsumerMode: tt.mode}
+			if got := cfg.GetKafkaConsumerGroupID(); got != tt.want {
+				t.Fatalf("got group %q, want %q", got, tt.want)
+			}
+
+			// Instance lain hanya berbagi group di mode shared
+			other := &Config{KafkaGroupID: "livechat-ws-group", InstanceID: "ws-2", KafkaConsumerMode: tt.mode}
+			if shared := other.GetKafkaConsumerGroupID() == cfg.GetKafkaConsumerGroupID(); shared != (tt.mode == KafkaConsumerShared) {
+				t.Fatalf("instances share group = %v in %s mode", shared, tt.mode)
+			}
+		})
+	}
+}
+
+func TestLoadConfigDefaultsToBroadcastGroupPerInstance(t *testing.T) {
+	t.Setenv("KAFKA_CONSUMER_MODE", "")
+	t.Setenv("KAFKA_GROUP_ID", "")
+	t.Setenv("INSTANCE_ID", "ws-1")
+
+	cfg := LoadConfig()
+	if cfg.KafkaConsumerMode != KafkaConsumerBroadcast {
+		t.Fatalf("got consumer mode %q, want %q", cfg.KafkaConsumerMode, KafkaConsumerBroadcast)
+	}
+	if got := cfg.GetKafkaConsumerGroupID(); got != "livechat-ws-group-ws-1" {
+		t.Fatalf("got group %q, want livechat-ws-group-ws-1", got)
+	}
+}
@@ -0,0 +1,193 @@
+package delivery
+
+import (
+	"crypto/subtle"
+	"errors"
+	"strconv"
+
+	"livechat-ws/internal/infrastructure/kafka"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+const (
+	defaultDeadLetterLimit = 50
+	maxDeadLetterLimit     = 500
+)
+
+// requireAdmin memastikan request membawa ADMIN_API_TOKEN sebagai Bearer token
+func (s *Server) requireAdmin(c *fiber.Ctx) error {
+	if s.config.AdminAPIToken == "" {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+			"success": false,
+			"message": "Admin API is not configured",
+		})
+	}
+
+	token := bearerToken(c.Get(fiber.HeaderAuthorization))
+	if subtle.ConstantTimeCompare([]byte(token), []byte(s.config.AdminAPIToken)) != 1 {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"success": false,
+			"message": "Invalid admin token",
+		})
+	}
+
+	return c.Next()
+}
+
+// handleListDeadLetters menampilkan record terbaru di dead-letter topic
+func (s *Server) handleListDeadLetters(c *fiber.Ctx) error {
+	if s.deadLetters == nil {
+		return deadLettersDisabled(c)
+	}
+
+	limit := c.QueryInt("limit", defaultDeadLetterLimit)
+	if limit <= 0 || limit > maxDeadLetterLimit {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"message": "limit must be between 1 and " + strconv.Itoa(maxDeadLetterLimit),
+		})
+	}
+
+	entries, err := s.deadLetters.List(c.Context(), limit)
+	if err != nil {
+		s.logger.Error("Failed to list dead letters", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"message": "Failed to list dead letters",
+			"error":   err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"message": "Dead letters retrieved successfully",
+		"data": fiber.Map{
+			"topic":   s.deadLetters.Topic(),
+			"entries": entries,
+		},
+	})
+}
+
+// handleReinjectDeadLetter mengirim ulang satu record DLQ ke topic asalnya
+func (s *Server) handleReinjectDeadLetter(c *fiber.Ctx) error {
+	if s.deadLetters == nil {
+		return deadLettersDisabled(c)
+	}
+
+	partition, err := strconv.Atoi(c.Params("partition"))
+	if err != nil || partition < 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"message": "Invalid partition",
+		})
+	}
+	offset, err := strconv.ParseInt(c.Params("offset"), 10, 64)
+	if err != nil || offset < 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"message": "Invalid offset",
+		})
+	}
+
+	entry, err := s.deadLetters.Reinject(c.Context(), partition, offset)
+	if err != nil {
+		if errors.Is(err, kafka.ErrDeadLetterNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"success": false,
+				"message": "Dead letter not found",
+			})
+		}
+		s.logger.Error("Failed to re-inject dead letter", "partition", partition, "offset", offset, "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"message": "Failed to re-inject dead letter",
+			"error":   err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"message": "Dead letter re-injected into " + entry.OriginalTopic,
+		"data":    entry,
+	})
+}
+
+// handleListSessions menampilkan session yang punya koneksi di instance ini
+func (s *Server) handleListSessions(c *fiber.Ctx) error {
+	return c.JSON(fiber.Map{
+		"success": true,
+		"message": "Sessions retrieved successfully",
+		"data": fiber.Map{
+			"instance_id": s.config.InstanceID,
+			"sessions":    s.wsManager.ListSessions(),
+		},
+	})
+}
+
+// handleListSessionConnections menampilkan detail koneksi sebuah session di instance ini.
+// Koneksi di instance lain tidak ikut, pakai /cluster untuk melihat instance yang ada.
+func (s *Server) handleListSessionConnections(c *fiber.Ctx) error {
+	sessionID, err := uuid.Parse(c.Params("session_id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"message": "Invalid session ID",
+			"error":   err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"message": "Connections retrieved successfully",
+		"data": fiber.Map{
+			"instance_id": s.config.InstanceID,
+			"session_id":  sessionID.String(),
+			"connections": s.wsManager.GetSessionConnections(sessionID.String()),
+		},
+	})
+}
+
+// handleClusterSummary menjumlahkan statistik semua instance yang terdaftar di Redis.
+// Session yang punya koneksi di beberapa instance dihitung sekali per instance.
+func (s *Server) handleClusterSummary(c *fiber.Ctx) error {
+	instances, err := s.redis.ListInstances(c.Context(), s.config.InstanceTTL)
+	if err != nil {
+		s.logger.Error("Failed to list instances", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"message": "Failed to list instances",
+			"error":   err.Error(),
+		})
+	}
+
+	totalConnections, totalSessions := 0, 0
+	byUserType := make(map[string]int)
+	for _, instance := range instances {
+		totalConnections += instance.Connections
+		totalSessions += instance.Sessions
+		for userType, count := range instance.ByUserType {
+			byUserType[userType] += count
+		}
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"message": "Cluster summary retrieved successfully",
+		"data": fiber.Map{
+			"instances":         instances,
+			"total_instances":   len(instances),
+			"total_connections": totalConnections,
+			"total_sessions":    totalSessions,
+			"by_user_type":      byUserType,
+		},
+	})
+}
+
+func deadLettersDisabled(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+		"success": false,
+		"message": "Dead-letter queue is not enabled",
+	})
+}
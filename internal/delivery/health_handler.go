@@ -0,0 +1,112 @@
+package delivery
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"livechat-ws/internal/infrastructure/broker"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	checkStatusOK   = "ok"
+	checkStatusFail = "fail"
+)
+
+// healthCheck adalah hasil satu pemeriksaan dependency di /readyz
+type healthCheck struct {
+	Status    string  `json:"status"`
+	LatencyMs float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// handleLivez hanya memastikan proses masih bisa melayani HTTP. Dependency sengaja
+// tidak diperiksa supaya Redis atau Kafka yang down tidak membuat pod di-restart.
+func (s *Server) handleLivez(c *fiber.Ctx) error {
+	return c.JSON(fiber.Map{"status": checkStatusOK})
+}
+
+// handleReadyz menjalankan semua pemeriksaan secara paralel dengan batas waktu
+// HEALTH_CHECK_TIMEOUT dan mengembalikan 503 jika salah satunya gagal
+func (s *Server) handleReadyz(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(c.Context(), s.config.HealthCheckTimeout)
+	defer cancel()
+
+	checks := map[string]func(context.Context) error{
+		"redis": s.redis.Ping,
+		"shutdown": func(context.Context) error {
+			if s.shuttingDown.Load() {
+				return errors.New("shutdown in progress")
+			}
+			return nil
+		},
+	}
+	if checker, ok := s.broker.(broker.HealthChecker); ok {
+		checks["broker"] = checker.Ping
+		checks["consumer"] = func(context.Context) error {
+			return checker.SubscriberHealth()
+		}
+	}
+
+	results := make(map[string]healthCheck, len(checks))
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	for name, check := range checks {
+		wg.Add(1)
+		go func(name string, check func(context.Context) error) {
+			defer wg.Done()
+			result := runHealthCheck(ctx, check)
+			mutex.Lock()
+			results[name] = result
+			mutex.Unlock()
+		}(name, check)
+	}
+	wg.Wait()
+
+	status := checkStatusOK
+	httpStatus := fiber.StatusOK
+	for name, result := range results {
+		if result.Status != checkStatusOK {
+			status = checkStatusFail
+			httpStatus = fiber.StatusServiceUnavailable
+			s.logger.Warn("Readiness check failed", "check", name, "error", result.Error)
+		}
+	}
+
+	return c.Status(httpStatus).JSON(fiber.Map{
+		"status":      status,
+		"broker":      s.config.BrokerType,
+		"instance_id": s.config.InstanceID,
+		"checks":      results,
+	})
+}
+
+// runHealthCheck menjalankan satu pemeriksaan dan mencatat latensinya. Pemeriksaan
+// yang tidak menghormati ctx tetap dianggap gagal begitu batas waktunya lewat.
+func runHealthCheck(ctx context.Context, check func(context.Context) error) healthCheck {
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		done <- check(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	result := healthCheck{
+		Status:    checkStatusOK,
+		LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if err != nil {
+		result.Status = checkStatusFail
+		result.Error = err.Error()
+	}
+	return result
+}
@@ -1,6 +1,12 @@
 package delivery
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	"livechat-ws/internal/domain"
+
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
 )
@@ -17,7 +23,7 @@ func (s *Server) handleGetSessionConnectionStatus(c *fiber.Ctx) error {
 	}
 
 	// Get connection status from Redis
-	status, err := s.redis.GetSessionUsers(c.Context(), sessionID.String())
+	status, err := s.redis.GetSessionUsers(c.Context(), sessionID.String(), s.config.InstanceID)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"success": false,
@@ -32,3 +38,82 @@ func (s *Server) handleGetSessionConnectionStatus(c *fiber.Ctx) error {
 		"data":    status,
 	})
 }
+
+// handleCreateConnectionTicket menerbitkan ticket sekali pakai untuk upgrade WebSocket.
+// Browser tidak bisa mengirim header Authorization saat upgrade, jadi JWT dikirim ke
+// endpoint ini lalu ticket dipakai sebagai ?ticket= di URL WebSocket.
+func (s *Server) handleCreateConnectionTicket(c *fiber.Ctx) error {
+	sessionID, err := uuid.Parse(c.Params("session_id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"message": "Invalid session ID",
+			"error":   err.Error(),
+		})
+	}
+
+	if s.jwtValidator == nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+			"success": false,
+			"message": "Authentication is not configured",
+		})
+	}
+
+	claims, err := s.jwtValidator.Validate(bearerToken(c.Get(fiber.HeaderAuthorization)))
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"success": false,
+			"message": "Invalid token",
+			"error":   err.Error(),
+		})
+	}
+
+	if !claims.CanAccessSession(sessionID.String()) {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"success": false,
+			"message": "Session not allowed",
+		})
+	}
+
+	ticket, err := generateTicket()
+	if err != nil {
+		s.logger.Error("Failed to generate connection ticket", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"message": "Failed to create ticket",
+		})
+	}
+
+	now := time.Now()
+	info := domain.ConnectionTicket{
+		UserID:    claims.UserID,
+		UserType:  claims.UserType,
+		SessionID: sessionID.String(),
+		IssuedAt:  now,
+	}
+	if err := s.redis.CreateConnectionTicket(c.Context(), ticket, info, s.config.WSTicketTTL); err != nil {
+		s.logger.Error("Failed to store connection ticket", "session_id", info.SessionID, "user_id", info.UserID, "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"message": "Failed to create ticket",
+			"error":   err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"success": true,
+		"message": "Connection ticket created successfully",
+		"data": domain.ConnectionTicketResponse{
+			Ticket:    ticket,
+			ExpiresAt: now.Add(s.config.WSTicketTTL),
+		},
+	})
+}
+
+func generateTicket() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
@@ -0,0 +1,145 @@
+package delivery
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"livechat-ws/internal/config"
+	"livechat-ws/internal/domain"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+const testTicketTTL = 30 * time.Second
+
+// createTicket meminta ticket lewat endpoint REST dan mengembalikan status HTTP-nya
+func createTicket(t *testing.T, app *fiber.App, sessionID, token string) (int, domain.ConnectionTicketResponse) {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/session/"+sessionID+"/ticket", nil)
+	if token != "" {
+		req.Header.Set(fiber.HeaderAuthorization, "Bearer "+token)
+	}
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		Data domain.ConnectionTicketResponse `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, body.Data
+}
+
+func TestCreateConnectionTicket(t *testing.T) {
+	validator, _ := newTestJWTValidator(t)
+	client, _ := newTestRedis(t)
+	sessionID := uuid.NewString()
+	s := &Server{config: &config.Config{WSTicketTTL: testTicketTTL}, redis: client, jwtValidator: validator, logger: newTestLogger()}
+
+	app := fiber.New()
+	app.Post("/api/session/:session_id/ticket", s.handleCreateConnectionTicket)
+
+	tests := []struct {
+		name       string
+		sessionID  string
+		token      string
+		wantStatus int
+	}{
+		{name: "valid", sessionID: sessionID, token: signToken(t, jwt.SigningMethodHS256, []byte(testJWTSecret), "", testClaims(sessionID)), wantStatus: fiber.StatusCreated},
+		{name: "invalid session id", sessionID: "not-a-uuid", token: signToken(t, jwt.SigningMethodHS256, []byte(testJWTSecret), "", testClaims("*")), wantStatus: fiber.StatusBadRequest},
+		{name: "missing token", sessionID: sessionID, wantStatus: fiber.StatusUnauthorized},
+		{name: "invalid token", sessionID: sessionID, token: "not-a-jwt", wantStatus: fiber.StatusUnauthorized},
+		{name: "session not allowed", sessionID: sessionID, token: signToken(t, jwt.SigningMethodHS256, []byte(testJWTSecret), "", testClaims(uuid.NewString())), wantStatus: fiber.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, ticket := createTicket(t, app, tt.sessionID, tt.token)
+			if status != tt.wantStatus {
+				t.Fatalf("got status %d, want %d", status, tt.wantStatus)
+			}
+			if tt.wantStatus != fiber.StatusCreated {
+				return
+			}
+			if ticket.Ticket == "" {
+				t.Fatal("empty ticket")
+			}
+			if ttl := time.Until(ticket.ExpiresAt); ttl <= 0 || ttl > testTicketTTL {
+				t.Fatalf("got expires_at %v, want within %v", ticket.ExpiresAt, testTicketTTL)
+			}
+		})
+	}
+}
+
+// TestConnectionTicketLifecycle memastikan ticket hanya bisa dipakai sekali, expire
+// setelah WSTicketTTL, dan hanya berlaku untuk session tempat ia diterbitkan
+func TestConnectionTicketLifecycle(t *testing.T) {
+	validator, _ := newTestJWTValidator(t)
+	client, server := newTestRedis(t)
+	sessionID := uuid.NewString()
+	s := &Server{config: &config.Config{WSAuthRequired: true, WSTicketTTL: testTicketTTL}, redis: client, jwtValidator: validator, logger: newTestLogger()}
+
+	app := newAuthTestApp(s)
+	app.Post("/api/session/:session_id/ticket", s.handleCreateConnectionTicket)
+	token := signToken(t, jwt.SigningMethodHS256, []byte(testJWTSecret), "", testClaims(sessionID, "*"))
+
+	issue := func(t *testing.T) string {
+		t.Helper()
+
+		status, ticket := createTicket(t, app, sessionID, token)
+		if status != fiber.StatusCreated {
+			t.Fatalf("create ticket: got status %d", status)
+		}
+		return ticket.Ticket
+	}
+	connect := func(t *testing.T, sessionID, ticket string) authResult {
+		t.Helper()
+		return authorize(t, app, "/ws/"+sessionID+"?ticket="+ticket, "")
+	}
+
+	t.Run("single use", func(t *testing.T) {
+		ticket := issue(t)
+		if result := connect(t, sessionID, ticket); result.Code != 0 || result.UserID != "user-1" || result.UserType != "customer" {
+			t.Fatalf("first use: got %+v, want user-1/customer", result)
+		}
+		if result := connect(t, sessionID, ticket); result.Code != CloseUnauthorized {
+			t.Fatalf("second use: got close code %d (%s), want %d", result.Code, result.Reason, CloseUnauthorized)
+		}
+	})
+
+	t.Run("expires", func(t *testing.T) {
+		ticket := issue(t)
+		server.FastForward(testTicketTTL + time.Second)
+		if result := connect(t, sessionID, ticket); result.Code != CloseUnauthorized {
+			t.Fatalf("got close code %d (%s), want %d", result.Code, result.Reason, CloseUnauthorized)
+		}
+	})
+
+	t.Run("bound to session", func(t *testing.T) {
+		// Token boleh mengakses semua session, tapi ticket terikat ke session saat diterbitkan
+		ticket := issue(t)
+		if result := connect(t, uuid.NewString(), ticket); result.Code != CloseForbidden {
+			t.Fatalf("other session: got close code %d (%s), want %d", result.Code, result.Reason, CloseForbidden)
+		}
+		// Ticket sudah dikonsumsi oleh percobaan tadi, jadi tidak bisa dipakai ulang di session asalnya
+		if result := connect(t, sessionID, ticket); result.Code != CloseUnauthorized {
+			t.Fatalf("reuse after rejection: got close code %d (%s), want %d", result.Code, result.Reason, CloseUnauthorized)
+		}
+	})
+
+	t.Run("unknown ticket", func(t *testing.T) {
+		if result := connect(t, sessionID, "unknown"); result.Code != CloseUnauthorized {
+			t.Fatalf("got close code %d (%s), want %d", result.Code, result.Reason, CloseUnauthorized)
+		}
+	})
+}
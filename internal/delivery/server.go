@@ -1,45 +1,58 @@
 package delivery
 
 import (
-	"log"
+	"context"
+	"log/slog"
+	"sync/atomic"
+	"time"
 
 	"livechat-ws/internal/config"
+	"livechat-ws/internal/infrastructure/auth"
+	"livechat-ws/internal/infrastructure/broker"
 	"livechat-ws/internal/infrastructure/kafka"
+	"livechat-ws/internal/infrastructure/metrics"
 	"livechat-ws/internal/infrastructure/redis"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/adaptor"
 	"github.com/gofiber/fiber/v2/middleware/cors"
-	"github.com/gofiber/fiber/v2/middleware/logger"
 	"github.com/gofiber/fiber/v2/middleware/recover"
 	"github.com/gofiber/websocket/v2"
 )
 
 type Server struct {
-	config        *config.Config
-	kafkaConsumer *kafka.KafkaConsumer
-	redis         *redis.RedisClient
-	wsManager     *WSManager
+	app          *fiber.App
+	config       *config.Config
+	broker       broker.Broker
+	deadLetters  *kafka.DeadLetterQueue
+	redis        *redis.RedisClient
+	wsManager    *WSManager
+	jwtValidator *auth.JWTValidator
+	logger       *slog.Logger
+	shuttingDown atomic.Bool
 }
 
-func NewServer(config *config.Config, kafkaConsumer *kafka.KafkaConsumer, redis *redis.RedisClient, wsManager *WSManager) *Server {
+func NewServer(config *config.Config, eventBroker broker.Broker, deadLetters *kafka.DeadLetterQueue, redis *redis.RedisClient, wsManager *WSManager, jwtValidator *auth.JWTValidator, logger *slog.Logger) *Server {
 	return &Server{
-		config:        config,
-		kafkaConsumer: kafkaConsumer,
-		redis:         redis,
-		wsManager:     wsManager,
+		app: fiber.New(fiber.Config{
+			AppName: "LiveChat WebSocket & REST Server",
+		}),
+		config:       config,
+		broker:       eventBroker,
+		deadLetters:  deadLetters,
+		redis:        redis,
+		wsManager:    wsManager,
+		jwtValidator: jwtValidator,
+		logger:       logger,
 	}
 }
 
 func (s *Server) Start() error {
-	app := fiber.New(fiber.Config{
-		AppName: "LiveChat WebSocket & REST Server",
-	})
+	app := s.app
 
 	// Global middleware
 	app.Use(recover.New())
-	app.Use(logger.New(logger.Config{
-		Format: "[${time}] ${status} - ${method} ${path} ${latency}\n",
-	}))
+	app.Use(s.logRequest)
 
 	// CORS middleware with best practices
 	corsConfig := cors.Config{
@@ -53,11 +66,11 @@ func (s *Server) Start() error {
 	// Set origins based on environment
 	if s.config.IsProduction() {
 		corsConfig.AllowOrigins = s.config.GetCORSOrigins()
-		log.Printf("CORS configured for production with origins: %s", corsConfig.AllowOrigins)
+		s.logger.Info("CORS configured for production", "origins", corsConfig.AllowOrigins)
 	} else {
 		corsConfig.AllowOrigins = "*"
 		corsConfig.AllowCredentials = false // Never allow credentials with wildcard origin
-		log.Printf("CORS configured for development with wildcard origin")
+		s.logger.Info("CORS configured for development with wildcard origin")
 	}
 
 	app.Use(cors.New(corsConfig))
@@ -73,27 +86,85 @@ func (s *Server) Start() error {
 		})
 	})
 
+	// Probe Kubernetes: liveness hanya memastikan proses hidup, readiness memeriksa dependency
+	app.Get("/livez", s.handleLivez)
+	app.Get("/readyz", s.handleReadyz)
+
+	// Prometheus metrics
+	app.Get("/metrics", adaptor.HTTPHandler(metrics.Handler()))
+
 	// REST API routes
 	api := app.Group("/api")
 	api.Get("/session/:session_id/connection-status", s.handleGetSessionConnectionStatus)
+	api.Post("/session/:session_id/ticket", s.handleCreateConnectionTicket)
+
+	// Admin API, dilindungi ADMIN_API_TOKEN
+	admin := api.Group("/admin", s.requireAdmin)
+	admin.Get("/dlq", s.handleListDeadLetters)
+	admin.Post("/dlq/:partition/:offset/reinject", s.handleReinjectDeadLetter)
+	admin.Get("/sessions", s.handleListSessions)
+	admin.Get("/sessions/:session_id/connections", s.handleListSessionConnections)
+	admin.Get("/cluster", s.handleClusterSummary)
 
 	// WebSocket middleware
 	app.Use("/ws", func(c *fiber.Ctx) error {
+		if s.shuttingDown.Load() {
+			return fiber.ErrServiceUnavailable
+		}
 		if websocket.IsWebSocketUpgrade(c) {
+			// IP dan User-Agent tidak tersedia lagi setelah upgrade, simpan untuk admin API
+			c.Locals(localsWSClient, &clientInfo{RemoteIP: c.IP(), UserAgent: c.Get(fiber.HeaderUserAgent)})
 			return c.Next()
 		}
 		return fiber.ErrUpgradeRequired
 	})
 
-	// WebSocket route
-	app.Get("/ws/:session_id/:user_id/:user_type", websocket.New(func(c *websocket.Conn) {
-		params := []string{c.Params("session_id"), c.Params("user_id"), c.Params("user_type")}
-		sessionID, userID, userType := params[0], params[1], params[2]
+	// WebSocket routes, identitas user diambil dari JWT (route dengan user_id/user_type
+	// dipertahankan untuk client lama dan harus cocok dengan isi token)
+	app.Get("/ws/:session_id", s.authorizeWebSocket, websocket.New(s.handleWebSocket))
+	app.Get("/ws/:session_id/:user_id/:user_type", s.authorizeWebSocket, websocket.New(s.handleWebSocket))
 
-		// Handle connection through WebSocket manager
-		s.wsManager.HandleConnection(c, sessionID, userID, userType)
-	}))
-
-	log.Printf("LiveChat server (WebSocket + REST) starting on port %s", s.config.Port)
+	s.logger.Info("LiveChat server (WebSocket + REST) starting", "port", s.config.Port)
 	return app.Listen(":" + s.config.Port)
 }
+
+// Shutdown berhenti menerima upgrade WebSocket, menutup semua koneksi yang ada beserta
+// cleanup presence-nya, lalu mematikan Fiber. Semua dilakukan dalam batas waktu ctx.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.shuttingDown.Store(true)
+	s.logger.Info("Server shutting down, no longer accepting WebSocket upgrades")
+
+	if err := s.wsManager.Shutdown(ctx); err != nil {
+		s.logger.Warn("Timed out waiting for WebSocket connections to close", "error", err)
+	}
+
+	return s.app.ShutdownWithContext(ctx)
+}
+
+// quietPaths adalah endpoint yang dipanggil berkala oleh Kubernetes dan Prometheus,
+// request-nya hanya dicatat di level debug
+var quietPaths = map[string]bool{
+	"/health":  true,
+	"/livez":   true,
+	"/readyz":  true,
+	"/metrics": true,
+}
+
+// logRequest mencatat setiap request HTTP. Query string tidak dicatat karena bisa
+// berisi ticket koneksi.
+func (s *Server) logRequest(c *fiber.Ctx) error {
+	start := time.Now()
+	err := c.Next()
+
+	status := c.Response().StatusCode()
+	if fiberErr, ok := err.(*fiber.Error); ok {
+		status = fiberErr.Code
+	}
+	level := slog.LevelInfo
+	if quietPaths[c.Path()] && status < fiber.StatusInternalServerError {
+		level = slog.LevelDebug
+	}
+	s.logger.Log(c.UserContext(), level, "HTTP request", "method", c.Method(), "path", c.Path(),
+		"status", status, "latency", time.Since(start).String())
+	return err
+}
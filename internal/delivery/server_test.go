@@ -0,0 +1,47 @@
+package delivery
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestLogRequestLevels(t *testing.T) {
+	var output bytes.Buffer
+	s := &Server{logger: slog.New(slog.NewTextHandler(&output, &slog.HandlerOptions{Level: slog.LevelDebug}))}
+
+	app := fiber.New()
+	app.Use(s.logRequest)
+	app.Get("/livez", func(c *fiber.Ctx) error { return c.SendStatus(fiber.StatusOK) })
+	app.Get("/readyz", func(c *fiber.Ctx) error { return c.SendStatus(fiber.StatusServiceUnavailable) })
+	app.Get("/metrics", func(c *fiber.Ctx) error { return c.SendStatus(fiber.StatusOK) })
+	app.Get("/api/session", func(c *fiber.Ctx) error { return c.SendStatus(fiber.StatusOK) })
+
+	tests := []struct {
+		path      string
+		wantLevel string
+	}{
+		{path: "/livez", wantLevel: "level=DEBUG"},
+		{path: "/metrics", wantLevel: "level=DEBUG"},
+		// Probe yang gagal tetap terlihat di level info
+		{path: "/readyz", wantLevel: "level=INFO"},
+		{path: "/api/session", wantLevel: "level=INFO"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			output.Reset()
+			if _, err := app.Test(httptest.NewRequest("GET", tt.path, nil)); err != nil {
+				t.Fatal(err)
+			}
+			line := output.String()
+			if !strings.Contains(line, "path="+tt.path) || !strings.Contains(line, tt.wantLevel) {
+				t.Fatalf("got log %q, want %s", line, tt.wantLevel)
+			}
+		})
+	}
+}
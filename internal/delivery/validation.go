@@ -0,0 +1,74 @@
+package delivery
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"livechat-ws/internal/domain"
+
+	"github.com/google/uuid"
+)
+
+const (
+	maxMessageLength   = 4000
+	maxAttachments     = 10
+	maxClientMessageID = 128
+	sendMessageTimeout = 10 * time.Second
+	// pendingMessageTTL membatasi umur reservasi pending, supaya client bisa mengirim
+	// ulang jika instance mati sebelum publish selesai
+	pendingMessageTTL  = 2 * sendMessageTimeout
+	defaultMessageType = "text"
+)
+
+// decodeMessageData mengubah field data (hasil decode generik) ke struct tujuan
+func decodeMessageData(data interface{}, v interface{}) error {
+	if data == nil {
+		return errors.New("missing data")
+	}
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, v)
+}
+
+func validateClientMessageID(clientMessageID string) error {
+	if len(clientMessageID) > maxClientMessageID {
+		return errors.New("client_message_id is too long")
+	}
+	return nil
+}
+
+// validateSendMessageRequest memastikan pesan ditujukan ke session koneksi ini
+// dan isinya masuk akal sebelum di-publish
+func validateSendMessageRequest(req *domain.SendMessageRequest, sessionID string) error {
+	if req.SessionID == uuid.Nil {
+		parsed, err := uuid.Parse(sessionID)
+		if err != nil {
+			return errors.New("invalid session ID")
+		}
+		req.SessionID = parsed
+	} else if req.SessionID.String() != sessionID {
+		return errors.New("session ID does not match connection")
+	}
+
+	req.Message = strings.TrimSpace(req.Message)
+	if req.Message == "" && len(req.Attachments) == 0 {
+		return errors.New("message or attachments is required")
+	}
+	if utf8.RuneCountInString(req.Message) > maxMessageLength {
+		return errors.New("message is too long")
+	}
+	if len(req.Attachments) > maxAttachments {
+		return errors.New("too many attachments")
+	}
+
+	if req.MessageType == "" {
+		req.MessageType = defaultMessageType
+	}
+
+	return nil
+}
@@ -0,0 +1,159 @@
+package delivery
+
+import (
+	"errors"
+	"log/slog"
+	"strconv"
+	"strings"
+
+	"livechat-ws/internal/infrastructure/redis"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/websocket/v2"
+)
+
+// Close code aplikasi (range 4000-4999) untuk penolakan autentikasi WebSocket
+const (
+	CloseUnauthorized = 4401
+	CloseForbidden    = 4403
+)
+
+const (
+	localsWSIdentity  = "ws_identity"
+	localsWSAuthError = "ws_auth_error"
+	localsWSClient    = "ws_client"
+)
+
+type wsIdentity struct {
+	UserID   string
+	UserType string
+}
+
+type wsAuthError struct {
+	Code   int
+	Reason string
+}
+
+// authorizeWebSocket memvalidasi ticket sekali pakai atau JWT sebelum upgrade dan
+// menyimpan identitas user di Locals. Penolakan tidak langsung dikembalikan sebagai
+// HTTP error karena browser tidak bisa membaca status upgrade, jadi koneksi tetap
+// di-upgrade lalu ditutup dengan close code yang sesuai sebelum masuk ke WSManager.
+func (s *Server) authorizeWebSocket(c *fiber.Ctx) error {
+	sessionID := c.Params("session_id")
+
+	if ticket := c.Query("ticket"); ticket != "" {
+		return s.authorizeTicket(c, sessionID, ticket)
+	}
+
+	token := bearerToken(c.Get(fiber.HeaderAuthorization))
+
+	if token == "" && !s.config.WSAuthRequired {
+		// Mode development: percaya parameter path seperti sebelumnya
+		if c.Params("user_id") == "" || c.Params("user_type") == "" {
+			return rejectWebSocket(c, CloseUnauthorized, "missing token")
+		}
+		c.Locals(localsWSIdentity, &wsIdentity{UserID: c.Params("user_id"), UserType: c.Params("user_type")})
+		return c.Next()
+	}
+
+	if s.jwtValidator == nil {
+		return rejectWebSocket(c, CloseUnauthorized, "authentication is not configured")
+	}
+
+	claims, err := s.jwtValidator.Validate(token)
+	if err != nil {
+		s.logger.Info("WebSocket authentication failed", "session_id", sessionID, "error", err)
+		return rejectWebSocket(c, CloseUnauthorized, "invalid token")
+	}
+
+	if !claims.CanAccessSession(sessionID) {
+		s.logger.Warn("User is not allowed to join session", "session_id", sessionID, "user_id", claims.UserID)
+		return rejectWebSocket(c, CloseForbidden, "session not allowed")
+	}
+
+	return s.acceptWebSocket(c, sessionID, &wsIdentity{UserID: claims.UserID, UserType: claims.UserType})
+}
+
+// authorizeTicket menukar ticket dengan identitas user. Ticket langsung dihapus dari
+// Redis saat dibaca, jadi tidak bisa di-replay walaupun tercatat di log proxy.
+func (s *Server) authorizeTicket(c *fiber.Ctx, sessionID, ticket string) error {
+	info, err := s.redis.ConsumeConnectionTicket(c.Context(), ticket)
+	if err != nil {
+		if !errors.Is(err, redis.ErrTicketNotFound) {
+			s.logger.Error("Failed to consume connection ticket", "session_id", sessionID, "error", err)
+		}
+		return rejectWebSocket(c, CloseUnauthorized, "invalid ticket")
+	}
+
+	if info.SessionID != sessionID {
+		s.logger.Warn("Ticket used on another session", "session_id", sessionID, "ticket_session_id", info.SessionID, "user_id", info.UserID)
+		return rejectWebSocket(c, CloseForbidden, "session not allowed")
+	}
+
+	return s.acceptWebSocket(c, sessionID, &wsIdentity{UserID: info.UserID, UserType: info.UserType})
+}
+
+// acceptWebSocket menyimpan identitas user setelah memastikan parameter path route
+// lama (user_id/user_type) cocok dengan identitas dari token atau ticket
+func (s *Server) acceptWebSocket(c *fiber.Ctx, sessionID string, identity *wsIdentity) error {
+	if userID := c.Params("user_id"); userID != "" && userID != identity.UserID {
+		s.logger.Warn("User ID mismatch", "session_id", sessionID, "path_user_id", userID, "user_id", identity.UserID)
+		return rejectWebSocket(c, CloseForbidden, "user mismatch")
+	}
+	if userType := c.Params("user_type"); userType != "" && userType != identity.UserType {
+		s.logger.Warn("User type mismatch", "session_id", sessionID, "path_user_type", userType, "user_id", identity.UserID)
+		return rejectWebSocket(c, CloseForbidden, "user type mismatch")
+	}
+
+	c.Locals(localsWSIdentity, identity)
+	return c.Next()
+}
+
+func rejectWebSocket(c *fiber.Ctx, code int, reason string) error {
+	c.Locals(localsWSAuthError, &wsAuthError{Code: code, Reason: reason})
+	return c.Next()
+}
+
+// handleWebSocket menutup koneksi yang gagal autentikasi, selain itu diteruskan ke WSManager
+func (s *Server) handleWebSocket(c *websocket.Conn) {
+	if authErr, ok := c.Locals(localsWSAuthError).(*wsAuthError); ok {
+		closeWithCode(c, authErr.Code, authErr.Reason, s.logger)
+		return
+	}
+
+	identity, ok := c.Locals(localsWSIdentity).(*wsIdentity)
+	if !ok {
+		closeWithCode(c, CloseUnauthorized, "unauthenticated", s.logger)
+		return
+	}
+
+	resumeFrom := int64(-1)
+	if value := c.Query("resume_from"); value != "" {
+		if seq, err := strconv.ParseInt(value, 10, 64); err == nil && seq >= 0 {
+			resumeFrom = seq
+		}
+	}
+
+	var client clientInfo
+	if info, ok := c.Locals(localsWSClient).(*clientInfo); ok {
+		client = *info
+	}
+
+	s.wsManager.HandleConnection(c, c.Params("session_id"), identity.UserID, identity.UserType, client, resumeFrom)
+}
+
+func closeWithCode(c *websocket.Conn, code int, reason string, logger *slog.Logger) {
+	defer c.Close()
+
+	if err := c.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason)); err != nil {
+		logger.Debug("Failed to send close frame", "error", err)
+	}
+}
+
+func bearerToken(header string) string {
+	const prefix = "Bearer "
+	if len(header) > len(prefix) && strings.EqualFold(header[:len(prefix)], prefix) {
+		return strings.TrimSpace(header[len(prefix):])
+	}
+	return ""
+}
@@ -0,0 +1,267 @@
+package delivery
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"livechat-ws/internal/config"
+	"livechat-ws/internal/infrastructure/auth"
+
+	fastws "github.com/fasthttp/websocket"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/websocket/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+const (
+	testJWTSecret = "test-secret"
+	testJWTKeyID  = "key-1"
+	testIssuer    = "chat-api"
+	testAudience  = "livechat-ws"
+)
+
+// authTestKeys berisi key RSA yang terdaftar di JWKS dan satu key asing
+type authTestKeys struct {
+	signing *rsa.PrivateKey
+	foreign *rsa.PrivateKey
+}
+
+// newTestJWTValidator membuat validator HS256 + RS256 dengan file JWKS berisi testJWTKeyID
+func newTestJWTValidator(t *testing.T) (*auth.JWTValidator, authTestKeys) {
+	t.Helper()
+
+	var keys authTestKeys
+	for _, key := range []**rsa.PrivateKey{&keys.signing, &keys.foreign} {
+		generated, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			t.Fatal(err)
+		}
+		*key = generated
+	}
+
+	jwks, err := json.Marshal(map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": testJWTKeyID,
+			"use": "sig",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(keys.signing.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(keys.signing.E)).Bytes()),
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwksFile := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(jwksFile, jwks, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	validator, err := auth.NewJWTValidator(testJWTSecret, jwksFile, testIssuer, testAudience)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return validator, keys
+}
+
+// testClaims adalah claims valid untuk user-1 (customer) di sessionID
+func testClaims(sessionIDs ...string) *auth.Claims {
+	return &auth.Claims{
+		UserID:     "user-1",
+		UserType:   "customer",
+		SessionIDs: sessionIDs,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    testIssuer,
+			Audience:  jwt.ClaimStrings{testAudience},
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+	}
+}
+
+func signToken(t *testing.T, method jwt.SigningMethod, key interface{}, kid string, claims jwt.Claims) string {
+	t.Helper()
+
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+// newAuthTestApp memasang authorizeWebSocket di depan handler yang mengembalikan hasil
+// autentikasi dari Locals
+func newAuthTestApp(s *Server) *fiber.App {
+	result := func(c *fiber.Ctx) error {
+		if authErr, ok := c.Locals(localsWSAuthError).(*wsAuthError); ok {
+			return c.JSON(fiber.Map{"code": authErr.Code, "reason": authErr.Reason})
+		}
+		identity, _ := c.Locals(localsWSIdentity).(*wsIdentity)
+		return c.JSON(fiber.Map{"user_id": identity.UserID, "user_type": identity.UserType})
+	}
+
+	app := fiber.New()
+	app.Get("/ws/:session_id", s.authorizeWebSocket, result)
+	app.Get("/ws/:session_id/:user_id/:user_type", s.authorizeWebSocket, result)
+	return app
+}
+
+type authResult struct {
+	Code     int    `json:"code"`
+	Reason   string `json:"reason"`
+	UserID   string `json:"user_id"`
+	UserType string `json:"user_type"`
+}
+
+func authorize(t *testing.T, app *fiber.App, path, token string) authResult {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	if token != "" {
+		req.Header.Set(fiber.HeaderAuthorization, "Bearer "+token)
+	}
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var result authResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func TestAuthorizeWebSocketToken(t *testing.T) {
+	validator, keys := newTestJWTValidator(t)
+	sessionID := uuid.NewString()
+	s := &Server{config: &config.Config{WSAuthRequired: true}, jwtValidator: validator, logger: newTestLogger()}
+	app := newAuthTestApp(s)
+
+	expired := testClaims(sessionID)
+	expired.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Minute))
+	withoutExpiry := testClaims(sessionID)
+	withoutExpiry.ExpiresAt = nil
+	otherIssuer := testClaims(sessionID)
+	otherIssuer.Issuer = "someone-else"
+	otherAudience := testClaims(sessionID)
+	otherAudience.Audience = jwt.ClaimStrings{"other-service"}
+	withoutUserType := testClaims(sessionID)
+	withoutUserType.UserType = ""
+
+	unsigned, err := jwt.NewWithClaims(jwt.SigningMethodNone, testClaims(sessionID)).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		path       string
+		token      string
+		wantCode   int
+		wantUserID string
+	}{
+		{name: "valid HS256", token: signToken(t, jwt.SigningMethodHS256, []byte(testJWTSecret), "", testClaims(sessionID)), wantUserID: "user-1"},
+		{name: "valid RS256", token: signToken(t, jwt.SigningMethodRS256, keys.signing, testJWTKeyID, testClaims(sessionID)), wantUserID: "user-1"},
+		{name: "wildcard session", token: signToken(t, jwt.SigningMethodHS256, []byte(testJWTSecret), "", testClaims("*")), wantUserID: "user-1"},
+		{name: "legacy path matching token", path: "/ws/" + sessionID + "/user-1/customer",
+			token: signToken(t, jwt.SigningMethodHS256, []byte(testJWTSecret), "", testClaims(sessionID)), wantUserID: "user-1"},
+
+		{name: "missing token", wantCode: CloseUnauthorized},
+		{name: "malformed token", token: "not-a-jwt", wantCode: CloseUnauthorized},
+		{name: "expired", token: signToken(t, jwt.SigningMethodHS256, []byte(testJWTSecret), "", expired), wantCode: CloseUnauthorized},
+		{name: "without exp", token: signToken(t, jwt.SigningMethodHS256, []byte(testJWTSecret), "", withoutExpiry), wantCode: CloseUnauthorized},
+		{name: "wrong HS256 secret", token: signToken(t, jwt.SigningMethodHS256, []byte("other-secret"), "", testClaims(sessionID)), wantCode: CloseUnauthorized},
+		{name: "RS256 signed by foreign key", token: signToken(t, jwt.SigningMethodRS256, keys.foreign, testJWTKeyID, testClaims(sessionID)), wantCode: CloseUnauthorized},
+		{name: "unknown kid", token: signToken(t, jwt.SigningMethodRS256, keys.signing, "key-2", testClaims(sessionID)), wantCode: CloseUnauthorized},
+		{name: "alg none", token: unsigned, wantCode: CloseUnauthorized},
+		{name: "alg not allowed", token: signToken(t, jwt.SigningMethodHS512, []byte(testJWTSecret), "", testClaims(sessionID)), wantCode: CloseUnauthorized},
+		{name: "wrong issuer", token: signToken(t, jwt.SigningMethodHS256, []byte(testJWTSecret), "", otherIssuer), wantCode: CloseUnauthorized},
+		{name: "wrong audience", token: signToken(t, jwt.SigningMethodHS256, []byte(testJWTSecret), "", otherAudience), wantCode: CloseUnauthorized},
+		{name: "missing user_type", token: signToken(t, jwt.SigningMethodHS256, []byte(testJWTSecret), "", withoutUserType), wantCode: CloseUnauthorized},
+
+		{name: "session not in session_ids", token: signToken(t, jwt.SigningMethodHS256, []byte(testJWTSecret), "", testClaims(uuid.NewString())), wantCode: CloseForbidden},
+		{name: "empty session_ids", token: signToken(t, jwt.SigningMethodHS256, []byte(testJWTSecret), "", testClaims()), wantCode: CloseForbidden},
+		{name: "legacy path with other user", path: "/ws/" + sessionID + "/user-2/customer",
+			token: signToken(t, jwt.SigningMethodHS256, []byte(testJWTSecret), "", testClaims(sessionID)), wantCode: CloseForbidden},
+		{name: "legacy path with other user type", path: "/ws/" + sessionID + "/user-1/agent",
+			token: signToken(t, jwt.SigningMethodHS256, []byte(testJWTSecret), "", testClaims(sessionID)), wantCode: CloseForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := tt.path
+			if path == "" {
+				path = "/ws/" + sessionID
+			}
+			result := authorize(t, app, path, tt.token)
+			if result.Code != tt.wantCode {
+				t.Fatalf("got close code %d (%s), want %d", result.Code, result.Reason, tt.wantCode)
+			}
+			if result.UserID != tt.wantUserID {
+				t.Fatalf("got user %q, want %q", result.UserID, tt.wantUserID)
+			}
+		})
+	}
+}
+
+// TestRejectedWebSocketReceivesCloseCode memastikan penolakan sampai ke client sebagai
+// close frame 4401/4403 setelah upgrade
+func TestRejectedWebSocketReceivesCloseCode(t *testing.T) {
+	validator, _ := newTestJWTValidator(t)
+	sessionID := uuid.NewString()
+	s := &Server{config: &config.Config{WSAuthRequired: true}, jwtValidator: validator, logger: newTestLogger()}
+
+	app := fiber.New(fiber.Config{DisableStartupMessage: true})
+	app.Get("/ws/:session_id", s.authorizeWebSocket, websocket.New(s.handleWebSocket))
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go app.Listener(listener)
+	t.Cleanup(func() { app.Shutdown() })
+
+	tests := []struct {
+		name     string
+		token    string
+		wantCode int
+	}{
+		{name: "invalid token", token: "not-a-jwt", wantCode: CloseUnauthorized},
+		{name: "session not allowed", token: signToken(t, jwt.SigningMethodHS256, []byte(testJWTSecret), "", testClaims(uuid.NewString())), wantCode: CloseForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			header.Set(fiber.HeaderAuthorization, "Bearer "+tt.token)
+			conn, _, err := fastws.DefaultDialer.Dial("ws://"+listener.Addr().String()+"/ws/"+sessionID, header)
+			if err != nil {
+				t.Fatalf("upgrade rejected before close frame: %v", err)
+			}
+			defer conn.Close()
+
+			conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			_, _, err = conn.ReadMessage()
+			var closeErr *fastws.CloseError
+			if !errors.As(err, &closeErr) || closeErr.Code != tt.wantCode {
+				t.Fatalf("got %v, want close code %d", err, tt.wantCode)
+			}
+		})
+	}
+}
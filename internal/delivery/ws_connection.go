@@ -0,0 +1,370 @@
+package delivery
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"livechat-ws/internal/domain"
+	"livechat-ws/internal/infrastructure/metrics"
+	"livechat-ws/internal/infrastructure/tracing"
+
+	"github.com/gofiber/websocket/v2"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// writeWait adalah batas waktu untuk menulis satu frame ke client
+const writeWait = 10 * time.Second
+
+// Kebijakan saat antrian kirim sebuah koneksi penuh (client lambat)
+const (
+	SlowConsumerDropOldest = "drop_oldest" // buang pesan paling lama di antrian
+	SlowConsumerDropTyping = "drop_typing" // buang typing indicator, putuskan jika pesan lain
+	SlowConsumerDisconnect = "disconnect"  // langsung putuskan koneksi
+)
+
+type outboundMessage struct {
+	data   []byte
+	seq    int64
+	typing bool
+	// span adalah span broadcast asal pesan, penulisan ke socket dicatat sebagai child-nya
+	span trace.SpanContext
+
+	// closeCode != 0 menandakan writePump harus mengirim close frame
+	// setelah semua pesan sebelumnya terkirim
+	closeCode   int
+	closeReason string
+}
+
+// clientInfo adalah data request upgrade yang tidak bisa dibaca lagi dari websocket.Conn
+type clientInfo struct {
+	RemoteIP  string
+	UserAgent string
+}
+
+type WSConnection struct {
+	ID          string
+	Conn        *websocket.Conn
+	UserID      string
+	UserType    string
+	SessionID   string
+	Client      clientInfo
+	ConnectedAt time.Time
+
+	// lastActivity adalah waktu (unix nano) pesan atau pong terakhir dari client
+	lastActivity atomic.Int64
+
+	// logger sudah berisi session_id, user_id, dan connection_id
+	logger *slog.Logger
+
+	// send adalah antrian keluar yang hanya dibaca oleh writePump,
+	// sehingga tidak ada concurrent write ke socket
+	send       chan outboundMessage
+	done       chan struct{}
+	writerDone chan struct{}
+	closeOnce  sync.Once
+	policy     string
+
+	// closeQueued true setelah close frame masuk antrian, pesan lain tidak diterima lagi
+	closeQueued atomic.Bool
+	// closeCode dan closeReason diisi closeWithCode sebelum done ditutup, writePump
+	// yang mengirim close frame-nya
+	closeCode   int
+	closeReason string
+
+	// Selama replay (resume), event live ditahan di pending agar urutannya
+	// tetap setelah event yang di-replay
+	replayMux sync.Mutex
+	replaying bool
+	pending   []outboundMessage
+}
+
+func newWSConnection(id string, c *websocket.Conn, sessionID, userID, userType string, client clientInfo, queueSize int, policy string, logger *slog.Logger) *WSConnection {
+	conn := &WSConnection{
+		ID:          id,
+		Conn:        c,
+		UserID:      userID,
+		UserType:    userType,
+		SessionID:   sessionID,
+		Client:      client,
+		ConnectedAt: time.Now(),
+		logger:      logger.With("session_id", sessionID, "user_id", userID, "connection_id", id),
+		send:        make(chan outboundMessage, queueSize),
+		done:        make(chan struct{}),
+		writerDone:  make(chan struct{}),
+		policy:      policy,
+	}
+	conn.lastActivity.Store(conn.ConnectedAt.UnixNano())
+	return conn
+}
+
+// touch mencatat aktivitas client (pesan atau pong)
+func (conn *WSConnection) touch() {
+	conn.lastActivity.Store(time.Now().UnixNano())
+}
+
+// Info mengembalikan snapshot koneksi untuk admin API
+func (conn *WSConnection) Info() domain.ConnectionInfo {
+	return domain.ConnectionInfo{
+		ConnectionID: conn.ID,
+		SessionID:    conn.SessionID,
+		UserID:       conn.UserID,
+		UserType:     conn.UserType,
+		ConnectedAt:  conn.ConnectedAt,
+		LastActivity: time.Unix(0, conn.lastActivity.Load()),
+		RemoteIP:     conn.Client.RemoteIP,
+		UserAgent:    conn.Client.UserAgent,
+	}
+}
+
+// sendJSON meng-encode pesan lalu memasukkannya ke antrian kirim
+func (conn *WSConnection) sendJSON(message interface{}) bool {
+	data, err := json.Marshal(message)
+	if err != nil {
+		conn.logger.Error("Failed to encode message", "error", err)
+		return false
+	}
+	return conn.enqueue(outboundMessage{data: data, typing: isTypingMessage(message)})
+}
+
+// enqueue tidak pernah blocking. Jika antrian penuh, kebijakan slow consumer diterapkan.
+func (conn *WSConnection) enqueue(msg outboundMessage) bool {
+	if conn.closing() || (msg.closeCode == 0 && conn.closeQueued.Load()) {
+		return false
+	}
+
+	select {
+	case conn.send <- msg:
+		return true
+	default:
+	}
+
+	switch conn.policy {
+	case SlowConsumerDropOldest:
+		select {
+		case oldest := <-conn.send:
+			if oldest.closeCode != 0 {
+				// Close frame tidak pernah dibuang, pesan baru yang dibuang
+				if !conn.requeueClose(oldest) {
+					conn.closeWithCode(oldest.closeCode, oldest.closeReason)
+				}
+				return false
+			}
+			metrics.SlowConsumerDrops.WithLabelValues(SlowConsumerDropOldest).Inc()
+			conn.logger.Warn("Send queue full, dropped oldest message")
+		default:
+		}
+		select {
+		case conn.send <- msg:
+			return true
+		default:
+			return false
+		}
+
+	case SlowConsumerDropTyping:
+		if msg.typing {
+			metrics.SlowConsumerDrops.WithLabelValues(SlowConsumerDropTyping).Inc()
+			conn.logger.Debug("Send queue full, dropped typing indicator")
+			return false
+		}
+	}
+
+	if msg.closeCode != 0 {
+		// gracefulClose menutup dengan kode miliknya sendiri
+		return false
+	}
+	metrics.SlowConsumerDrops.WithLabelValues(SlowConsumerDisconnect).Inc()
+	conn.logger.Warn("Send queue full, disconnecting slow consumer")
+	conn.closeWithCode(websocket.ClosePolicyViolation, "slow consumer")
+	return false
+}
+
+// enqueueWait menunggu sampai antrian punya tempat, dipakai replay yang berjalan di
+// goroutine koneksi itu sendiri. Broadcast tetap memakai enqueue yang tidak pernah blocking.
+func (conn *WSConnection) enqueueWait(ctx context.Context, msg outboundMessage) bool {
+	if conn.closeQueued.Load() {
+		return false
+	}
+
+	select {
+	case conn.send <- msg:
+		return true
+	case <-conn.done:
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (conn *WSConnection) requeueClose(msg outboundMessage) bool {
+	select {
+	case conn.send <- msg:
+		return true
+	default:
+		return false
+	}
+}
+
+// deliver dipakai untuk event broadcast. Jika koneksi sedang replay, event ditahan dulu.
+func (conn *WSConnection) deliver(msg outboundMessage) bool {
+	conn.replayMux.Lock()
+	if conn.replaying {
+		conn.pending = append(conn.pending, msg)
+		conn.replayMux.Unlock()
+		return true
+	}
+	conn.replayMux.Unlock()
+
+	return conn.enqueue(msg)
+}
+
+func (conn *WSConnection) beginReplay() {
+	conn.replayMux.Lock()
+	defer conn.replayMux.Unlock()
+	conn.replaying = true
+}
+
+// finishReplay mengirim event live yang ditahan selama replay, kecuali yang
+// seq-nya sudah ikut terkirim lewat replay
+func (conn *WSConnection) finishReplay(lastReplayedSeq int64) {
+	conn.replayMux.Lock()
+	defer conn.replayMux.Unlock()
+
+	for _, msg := range conn.pending {
+		if msg.seq == 0 || msg.seq > lastReplayedSeq {
+			conn.enqueue(msg)
+		}
+	}
+	conn.pending = nil
+	conn.replaying = false
+}
+
+// writePump adalah satu-satunya goroutine yang menulis data frame ke socket.
+// Ping heartbeat juga dikirim dari sini.
+func (conn *WSConnection) writePump(pingInterval time.Duration) {
+	ticker := time.NewTicker(pingInterval)
+	defer func() {
+		ticker.Stop()
+		close(conn.writerDone)
+	}()
+
+	for {
+		select {
+		case <-conn.done:
+			if conn.closeCode != 0 {
+				conn.writeCloseFrame(conn.closeCode, conn.closeReason)
+				conn.Conn.Close()
+			}
+			return
+
+		case msg := <-conn.send:
+			if msg.closeCode != 0 {
+				// Close handshake: client punya waktu writeWait untuk membalas close frame,
+				// setelah itu read loop timeout dan koneksi dibersihkan. done ditutup supaya
+				// read loop berhenti memperpanjang read deadline.
+				if !conn.writeCloseFrame(msg.closeCode, msg.closeReason) {
+					conn.close()
+					return
+				}
+				conn.closeOnce.Do(func() { close(conn.done) })
+				conn.Conn.SetReadDeadline(time.Now().Add(writeWait))
+				return
+			}
+
+			span := conn.startWriteSpan(msg)
+			conn.Conn.SetWriteDeadline(time.Now().Add(writeWait))
+			err := conn.Conn.WriteMessage(websocket.TextMessage, msg.data)
+			tracing.RecordError(span, err)
+			span.End()
+			if err != nil {
+				metrics.WriteFailures.Inc()
+				conn.logger.Warn("Failed to write to connection", "error", err)
+				conn.close()
+				return
+			}
+
+		case <-ticker.C:
+			if err := conn.Conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeWait)); err != nil {
+				metrics.WriteFailures.Inc()
+				conn.logger.Warn("Failed to send ping", "error", err)
+				conn.close()
+				return
+			}
+		}
+	}
+}
+
+// startWriteSpan membuat span ws.write untuk pesan hasil broadcast yang ter-trace.
+// Pesan lain (welcome, ack, replay) mendapat span no-op.
+func (conn *WSConnection) startWriteSpan(msg outboundMessage) trace.Span {
+	if !msg.span.IsValid() {
+		return trace.SpanFromContext(context.Background())
+	}
+
+	parent := trace.ContextWithSpanContext(context.Background(), msg.span)
+	_, span := tracing.Tracer().Start(parent, "ws.write", trace.WithAttributes(
+		tracing.AttrSessionID.String(conn.SessionID),
+		tracing.AttrConnectionID.String(conn.ID),
+		tracing.AttrSeq.Int64(msg.seq),
+	))
+	return span
+}
+
+func (conn *WSConnection) writeCloseFrame(code int, reason string) bool {
+	if err := conn.Conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(writeWait)); err != nil {
+		conn.logger.Debug("Failed to send close frame", "error", err)
+		return false
+	}
+	return true
+}
+
+// gracefulClose mengantrikan close frame di belakang pesan yang sudah ada di antrian
+func (conn *WSConnection) gracefulClose(code int, reason string) {
+	conn.closeQueued.Store(true)
+	if !conn.enqueue(outboundMessage{closeCode: code, closeReason: reason}) {
+		conn.closeWithCode(code, reason)
+	}
+}
+
+// closing true setelah close frame dikirim atau koneksi mulai ditutup. Read loop
+// tidak lagi memperpanjang read deadline supaya close handshake selesai dalam writeWait.
+func (conn *WSConnection) closing() bool {
+	select {
+	case <-conn.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// close menghentikan writePump dan menutup socket sehingga read loop ikut berhenti
+func (conn *WSConnection) close() {
+	conn.closeOnce.Do(func() {
+		close(conn.done)
+		conn.Conn.Close()
+	})
+}
+
+// closeWithCode menghentikan koneksi tanpa menunggu socket. Close frame dikirim oleh
+// writePump: WriteControl menunggu write lock yang dipegang WriteMessage client lambat
+// sampai writeWait, dan pemanggilnya bisa goroutine broadcast atau consumer broker.
+func (conn *WSConnection) closeWithCode(code int, reason string) {
+	conn.closeOnce.Do(func() {
+		conn.closeCode = code
+		conn.closeReason = reason
+		close(conn.done)
+	})
+}
+
+func isTypingMessage(message interface{}) bool {
+	switch m := message.(type) {
+	case domain.WebSocketResponse:
+		return m.Type == "typing_indicator"
+	case *domain.WebSocketResponse:
+		return m.Type == "typing_indicator"
+	}
+	return false
+}
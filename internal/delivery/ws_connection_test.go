@@ -0,0 +1,63 @@
+package delivery
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/gofiber/websocket/v2"
+)
+
+// TestSlowConsumerCloseDoesNotTouchSocket memastikan kebijakan disconnect tidak menulis ke
+// socket dari goroutine pemanggil. Conn nil akan panic jika disentuh.
+func TestSlowConsumerCloseDoesNotTouchSocket(t *testing.T) {
+	for _, policy := range []string{SlowConsumerDisconnect, SlowConsumerDropTyping} {
+		t.Run(policy, func(t *testing.T) {
+			conn := newWSConnection("conn", nil, "session", "user", "customer", clientInfo{}, 1, policy, newTestLogger())
+			if !conn.enqueue(outboundMessage{data: []byte(`{}`)}) {
+				t.Fatal("first message rejected")
+			}
+
+			if conn.enqueue(outboundMessage{data: []byte(`{}`)}) {
+				t.Fatal("message accepted on full queue")
+			}
+			if !conn.closing() {
+				t.Fatal("slow consumer not disconnected")
+			}
+			if conn.closeCode != websocket.ClosePolicyViolation {
+				t.Fatalf("got close code %d, want %d", conn.closeCode, websocket.ClosePolicyViolation)
+			}
+		})
+	}
+}
+
+func TestDropOldestKeepsQueuedCloseFrame(t *testing.T) {
+	for _, queueSize := range []int{1, 2} {
+		t.Run(fmt.Sprintf("queue %d", queueSize), func(t *testing.T) {
+			conn := newWSConnection("conn", nil, "session", "user", "customer", clientInfo{}, queueSize, SlowConsumerDropOldest, newTestLogger())
+			for i := 0; i < queueSize; i++ {
+				conn.enqueue(outboundMessage{data: []byte(`{}`)})
+			}
+
+			// Antrian penuh: pesan lama dibuang supaya close frame masuk
+			conn.gracefulClose(websocket.CloseGoingAway, "server shutting down")
+			if conn.closing() {
+				t.Fatal("close frame not queued behind pending messages")
+			}
+
+			// Pesan setelah close frame ditolak dan tidak menggusurnya
+			for i := 0; i < queueSize+1; i++ {
+				if conn.enqueue(outboundMessage{data: []byte(`{}`)}) {
+					t.Fatal("message accepted after close frame")
+				}
+			}
+
+			var last outboundMessage
+			for len(conn.send) > 0 {
+				last = <-conn.send
+			}
+			if last.closeCode != websocket.CloseGoingAway {
+				t.Fatalf("close frame dropped, last queued message %+v", last)
+			}
+		})
+	}
+}
@@ -2,39 +2,113 @@ package delivery
 
 import (
 	"context"
-	"log"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"sort"
 	"sync"
 	"time"
 
+	"livechat-ws/internal/config"
 	"livechat-ws/internal/domain"
-	"livechat-ws/internal/infrastructure/kafka"
+	"livechat-ws/internal/infrastructure/broker"
+	"livechat-ws/internal/infrastructure/logging"
+	"livechat-ws/internal/infrastructure/metrics"
 	"livechat-ws/internal/infrastructure/redis"
+	"livechat-ws/internal/infrastructure/tracing"
 
 	"github.com/gofiber/websocket/v2"
 	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/trace"
 )
 
-type WSConnection struct {
-	Conn      *websocket.Conn
-	UserID    string
-	UserType  string
-	SessionID string
-	writeMux  sync.Mutex // Mutex untuk mencegah concurrent write
-}
-
 type WSManager struct {
-	kafkaProducer *kafka.KafkaProducer
-	redisClient   *redis.RedisClient
+	config      *config.Config
+	broker      broker.Broker
+	redisClient *redis.RedisClient
+	logger      *slog.Logger
+	startedAt   time.Time
 	// Store active connections by session ID
 	connections map[string][]*WSConnection
 	mutex       sync.RWMutex
+
+	// handlers menghitung HandleConnection yang masih berjalan (termasuk cleanup presence)
+	handlers     sync.WaitGroup
+	shuttingDown bool
 }
 
-func NewWSManager(kafkaProducer *kafka.KafkaProducer, redisClient *redis.RedisClient) *WSManager {
+func NewWSManager(config *config.Config, eventBroker broker.Broker, redisClient *redis.RedisClient, logger *slog.Logger) *WSManager {
 	return &WSManager{
-		kafkaProducer: kafkaProducer,
-		redisClient:   redisClient,
-		connections:   make(map[string][]*WSConnection),
+		config:      config,
+		broker:      eventBroker,
+		redisClient: redisClient,
+		logger:      logger,
+		startedAt:   time.Now(),
+		connections: make(map[string][]*WSConnection),
+	}
+}
+
+// trackHandler mendaftarkan HandleConnection yang baru, ditolak jika shutdown sudah dimulai
+func (w *WSManager) trackHandler() bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.shuttingDown {
+		return false
+	}
+	w.handlers.Add(1)
+	return true
+}
+
+// Shutdown mengirim event server_shutdown ke semua koneksi, menutupnya dengan close code
+// 1001 (going away), lalu menunggu semua handler selesai membersihkan presence di Redis
+// atau sampai ctx habis.
+func (w *WSManager) Shutdown(ctx context.Context) error {
+	w.mutex.Lock()
+	w.shuttingDown = true
+	connections := make([]*WSConnection, 0)
+	for _, conns := range w.connections {
+		connections = append(connections, conns...)
+	}
+	w.mutex.Unlock()
+
+	w.logger.Info("Closing WebSocket connections for shutdown", "connections", len(connections))
+
+	hint := w.config.WSReconnectHint
+	for _, conn := range connections {
+		// Jitter supaya client tidak reconnect bersamaan ke instance lain
+		reconnectAfter := hint
+		if hint > 0 {
+			reconnectAfter += time.Duration(rand.Int63n(int64(hint)))
+		}
+
+		response := domain.WebSocketResponse{
+			Type:    "server_shutdown",
+			Success: true,
+			Data: map[string]interface{}{
+				"session_id":         conn.SessionID,
+				"reconnect":          true,
+				"reconnect_after_ms": reconnectAfter.Milliseconds(),
+				"timestamp":          time.Now().Format(time.RFC3339),
+			},
+		}
+		conn.sendJSON(response)
+		conn.gracefulClose(websocket.CloseGoingAway, "server shutting down")
+	}
+
+	done := make(chan struct{})
+	go func() {
+		w.handlers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		w.logger.Info("All WebSocket connections closed")
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
 	}
 }
 
@@ -46,34 +120,89 @@ func (w *WSManager) addConnection(sessionID string, conn *WSConnection) {
 		w.connections[sessionID] = make([]*WSConnection, 0)
 	}
 	w.connections[sessionID] = append(w.connections[sessionID], conn)
-	log.Printf("Added connection: %s (%s) to session %s. Total connections: %d",
-		conn.UserID, conn.UserType, sessionID, len(w.connections[sessionID]))
+	metrics.ActiveConnections.WithLabelValues(conn.UserType).Inc()
+	metrics.ActiveSessions.Set(float64(len(w.connections)))
+	conn.logger.Debug("Added connection", "session_connections", len(w.connections[sessionID]))
 }
 
-func (w *WSManager) removeConnection(sessionID, userID string) {
+// removeConnection menghapus satu koneksi berdasarkan connection ID dan mengembalikan
+// jumlah koneksi lain milik user yang sama di session tersebut pada instance ini
+func (w *WSManager) removeConnection(sessionID, connectionID string) int {
 	w.mutex.Lock()
 	defer w.mutex.Unlock()
 
-	if connections, exists := w.connections[sessionID]; exists {
-		for i, conn := range connections {
-			if conn.UserID == userID {
-				// Remove connection from slice
-				w.connections[sessionID] = append(connections[:i], connections[i+1:]...)
-				log.Printf("Removed connection: %s from session %s. Remaining connections: %d",
-					userID, sessionID, len(w.connections[sessionID]))
-				break
-			}
+	connections, exists := w.connections[sessionID]
+	if !exists {
+		return 0
+	}
+
+	var userID string
+	for i, conn := range connections {
+		if conn.ID == connectionID {
+			userID = conn.UserID
+			// Remove connection from slice
+			w.connections[sessionID] = append(connections[:i], connections[i+1:]...)
+			metrics.ActiveConnections.WithLabelValues(conn.UserType).Dec()
+			conn.logger.Debug("Removed connection", "session_connections", len(w.connections[sessionID]))
+			break
 		}
+	}
+
+	// Clean up empty session
+	if len(w.connections[sessionID]) == 0 {
+		delete(w.connections, sessionID)
+		metrics.ActiveSessions.Set(float64(len(w.connections)))
+		w.logger.Debug("Cleaned up empty session", "session_id", sessionID)
+		return 0
+	}
 
-		// Clean up empty session
-		if len(w.connections[sessionID]) == 0 {
-			delete(w.connections, sessionID)
-			log.Printf("Cleaned up empty session: %s", sessionID)
+	remaining := 0
+	for _, conn := range w.connections[sessionID] {
+		if userID != "" && conn.UserID == userID {
+			remaining++
 		}
 	}
+	return remaining
 }
 
-func (w *WSManager) broadcastToSession(sessionID string, message interface{}) {
+// broadcastToSession mengirim event ke semua koneksi session di instance ini. Setiap event
+// mendapat seq dari Redis; eventID membuat event yang sama memakai seq yang sama di
+// semua instance (kosongkan untuk event yang hanya dikirim dari instance ini).
+// Jika event gagal dicatat (misalnya Redis sedang bermasalah), event tetap dikirim tanpa
+// seq daripada hilang; client mendeteksi celahnya lewat resync_required saat resume.
+func (w *WSManager) broadcastToSession(ctx context.Context, sessionID, eventID string, message domain.WebSocketResponse) (err error) {
+	start := time.Now()
+
+	ctx, span := tracing.Tracer().Start(ctx, "ws.broadcast", trace.WithAttributes(
+		tracing.AttrSessionID.String(sessionID),
+		tracing.AttrEventID.String(eventID),
+		tracing.AttrEventType.String(message.Type),
+	))
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	// Event dicatat ke event log session (tanpa seq) sekaligus mendapat seq-nya,
+	// walaupun tidak ada koneksi di instance ini, supaya bisa di-replay saat resume
+	message.Seq = 0
+	payload, err := json.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("failed to encode broadcast message for session %s: %w", sessionID, err)
+	}
+
+	seq, err := w.redisClient.AppendSessionEvent(ctx, sessionID, eventID, payload,
+		int64(w.config.SessionEventLogSize), w.config.SessionEventRetention, w.config.SessionEventDedupTTL)
+	if err != nil {
+		// Tetap kirim tanpa seq daripada event hilang
+		w.logger.Warn("Failed to append event to session log, delivering without seq",
+			"session_id", sessionID, "event_id", eventID, "error", err)
+		span.AddEvent("session log append failed")
+		seq = 0
+	}
+	message.Seq = seq
+	span.SetAttributes(tracing.AttrSeq.Int64(seq))
+
 	w.mutex.RLock()
 	connections := make([]*WSConnection, 0)
 	if conns, exists := w.connections[sessionID]; exists {
@@ -84,124 +213,254 @@ func (w *WSManager) broadcastToSession(sessionID string, message interface{}) {
 	w.mutex.RUnlock()
 
 	if len(connections) == 0 {
-		log.Printf("No active connections found for session %s", sessionID)
-		return
+		span.SetAttributes(tracing.AttrFanout.Int(0))
+		metrics.BroadcastFanout.Observe(0)
+		metrics.BroadcastDuration.Observe(time.Since(start).Seconds())
+		w.logger.Debug("No active connections for session", "session_id", sessionID)
+		return nil
 	}
 
-	successCount := 0
-	var wg sync.WaitGroup
+	data, err := json.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("failed to encode broadcast message for session %s: %w", sessionID, err)
+	}
 
-	// Broadcast ke semua koneksi secara concurrent tapi thread-safe
+	// Broadcast hanya memasukkan pesan ke antrian tiap koneksi, penulisan ke socket
+	// dilakukan oleh writePump masing-masing sehingga client lambat tidak menahan yang lain
+	outbound := outboundMessage{data: data, seq: seq, typing: isTypingMessage(message), span: span.SpanContext()}
+	queued := 0
 	for _, conn := range connections {
-		wg.Add(1)
-		go func(c *WSConnection) {
-			defer wg.Done()
-			defer func() {
-				if r := recover(); r != nil {
-					log.Printf("Recovered from panic while broadcasting to user %s: %v", c.UserID, r)
-				}
-			}()
-
-			if err := c.safeWriteJSON(message); err != nil {
-				log.Printf("Failed to send message to client %s: %v", c.UserID, err)
-				// Hapus koneksi yang tidak valid
-				w.removeConnection(sessionID, c.UserID)
-			} else {
-				successCount++
+		if conn.deliver(outbound) {
+			queued++
+		}
+	}
+
+	span.SetAttributes(tracing.AttrFanout.Int(queued))
+	metrics.BroadcastFanout.Observe(float64(queued))
+	metrics.BroadcastDuration.Observe(time.Since(start).Seconds())
+
+	w.logger.Debug("Broadcasted message to session", "session_id", sessionID,
+		"queued", queued, "connections", len(connections))
+	return nil
+}
+
+// replaySessionEvents mengirim event dengan seq > resumeFrom dari event log session.
+// Event dibaca per halaman dan setiap halaman menunggu tempat di antrian kirim, sehingga
+// seluruh log (sampai SESSION_EVENT_LOG_SIZE) bisa di-replay tanpa memicu slow consumer
+// policy. Typing indicator tidak di-replay karena statusnya sudah basi.
+// Jika event yang terlewat sudah tidak lengkap di log, client diminta resync.
+// Mengembalikan seq terakhir yang di-replay; event live yang ditahan dengan seq lebih
+// besar (termasuk typing indicator yang baru) tetap dikirim oleh finishReplay.
+func (w *WSManager) replaySessionEvents(ctx context.Context, conn *WSConnection, resumeFrom int64) int64 {
+	pageSize := int64(w.config.WSSendQueueSize)
+	if pageSize < 1 {
+		pageSize = 1
+	}
+
+	cursor := resumeFrom
+	var lastSeq int64
+	replayed, skipped := 0, 0
+	for {
+		events, complete, err := w.redisClient.GetSessionEventsSince(ctx, conn.SessionID, cursor, pageSize)
+		if err != nil {
+			conn.logger.Error("Failed to read session event log", "error", err)
+			complete = false
+		}
+
+		if !complete {
+			conn.logger.Info("Cannot resume session, resync required", "resume_from", resumeFrom, "replayed", replayed)
+			response := domain.WebSocketResponse{
+				Type:    "resync_required",
+				Success: true,
+				Data: map[string]interface{}{
+					"session_id":  conn.SessionID,
+					"resume_from": resumeFrom,
+					"timestamp":   time.Now().Format(time.RFC3339),
+				},
+			}
+			conn.sendJSON(response)
+			return lastSeq
+		}
+
+		for _, event := range events {
+			cursor = event.Seq
+
+			var message domain.WebSocketResponse
+			if err := json.Unmarshal(event.Payload, &message); err != nil {
+				conn.logger.Error("Failed to decode session event", "seq", event.Seq, "error", err)
+				continue
+			}
+			if isTypingMessage(message) {
+				skipped++
+				continue
+			}
+			message.Seq = event.Seq
+
+			data, err := json.Marshal(message)
+			if err != nil {
+				continue
 			}
-		}(conn)
+			if !conn.enqueueWait(ctx, outboundMessage{data: data, seq: event.Seq}) {
+				conn.logger.Info("Connection closed during replay", "resume_from", resumeFrom, "replayed", replayed)
+				return lastSeq
+			}
+			lastSeq = event.Seq
+			replayed++
+		}
+
+		if int64(len(events)) < pageSize {
+			break
+		}
 	}
 
-	wg.Wait()
-	log.Printf("Broadcasted message to session %s: %d/%d clients received",
-		sessionID, successCount, len(connections))
+	conn.logger.Info("Replayed session events", "events", replayed, "skipped_typing", skipped, "resume_from", resumeFrom)
+	return lastSeq
 }
 
-func (w *WSManager) HandleConnection(c *websocket.Conn, sessionID, userID, userType string) {
+// HandleConnection melayani satu koneksi WebSocket sampai tertutup. resumeFrom adalah seq
+// terakhir yang diterima client sebelum reconnect, atau -1 jika client tidak melakukan resume.
+func (w *WSManager) HandleConnection(c *websocket.Conn, sessionID, userID, userType string, client clientInfo, resumeFrom int64) {
 	defer c.Close()
 
+	if !w.trackHandler() {
+		closeWithCode(c, websocket.CloseGoingAway, "server shutting down", w.logger)
+		return
+	}
+	defer w.handlers.Done()
+
 	ctx := context.Background()
 
 	// Validate session ID format
 	if _, err := uuid.Parse(sessionID); err != nil {
-		log.Printf("Invalid session ID format: %s", sessionID)
+		w.logger.Warn("Invalid session ID format", "session_id", sessionID, "user_id", userID)
 		w.sendErrorResponse(c, "Invalid session ID format")
 		return
 	}
 
-	// Create connection object
-	wsConn := &WSConnection{
-		Conn:      c,
-		UserID:    userID,
-		UserType:  userType,
-		SessionID: sessionID,
+	// Create connection object, setiap tab/perangkat mendapat connection ID sendiri
+	wsConn := newWSConnection(uuid.New().String(), c, sessionID, userID, userType, client,
+		w.config.WSSendQueueSize, w.config.WSSlowConsumerPolicy, w.logger)
+
+	// Event live ditahan sampai replay selesai
+	if resumeFrom >= 0 {
+		wsConn.beginReplay()
 	}
 
-	// Add to connections map
+	// Writer goroutine harus sudah berhenti sebelum handler return, karena
+	// websocket.Conn dikembalikan ke pool oleh fiber setelahnya
+	go wsConn.writePump(w.config.WSPingInterval)
+	defer func() {
+		wsConn.close()
+		<-wsConn.writerDone
+	}()
+
+	// Add to connections map and Redis
 	w.addConnection(sessionID, wsConn)
+	userConnections, err := w.redisClient.AddUserToSession(ctx, sessionID, userID, userType, wsConn.ID, w.config.InstanceID)
+	if err != nil {
+		wsConn.logger.Error("Failed to add user to Redis session", "error", err)
+		userConnections = 1
+	}
+
 	defer func() {
-		// First: Broadcast disconnect event BEFORE removing user
-		log.Printf("User %s (%s) disconnecting from session %s", userID, userType, sessionID)
+		wsConn.logger.Debug("User disconnecting from session")
 
 		// Remove from connections map and Redis
-		w.removeConnection(sessionID, userID)
+		localRemaining := w.removeConnection(sessionID, wsConn.ID)
+		remaining, err := w.redisClient.RemoveUserFromSession(ctx, sessionID, userID, userType, wsConn.ID, w.config.InstanceID)
+		if err != nil {
+			wsConn.logger.Error("Failed to remove user from Redis session", "error", err)
+			remaining = int64(localRemaining)
+		}
+
+		// User baru dianggap disconnect setelah koneksi terakhirnya (di semua instance) tertutup
+		if remaining > 0 {
+			wsConn.logger.Debug("User still has connections in session", "remaining", remaining)
+			return
+		}
 
-		// Then: Broadcast updated connection status AFTER user removed with context
+		// Broadcast updated connection status AFTER user removed with context
 		w.broadcastConnectionStatusWithContext(sessionID, "user_disconnected", userID)
 	}()
 
-	// Add to Redis
-	if err := w.redisClient.AddUserToSession(ctx, sessionID, userID, userType); err != nil {
-		log.Printf("Failed to add user to Redis session: %v", err)
+	// Send connection status updates, event user_connected hanya untuk koneksi pertama user
+	eventType := ""
+	if userConnections == 1 {
+		eventType = "user_connected"
 	}
-	defer func() {
-		if err := w.redisClient.RemoveUserFromSession(ctx, sessionID, userID, userType); err != nil {
-			log.Printf("Failed to remove user from Redis session: %v", err)
-		}
-	}()
-
-	// Send connection status updates with connect context
-	w.broadcastConnectionStatusWithContext(sessionID, "user_connected", userID)
+	w.broadcastConnectionStatusWithContext(sessionID, eventType, userID)
 
 	// Send welcome message
-	w.sendWelcomeMessage(c, sessionID, userID, userType)
+	w.sendWelcomeMessage(ctx, wsConn)
 
-	log.Printf("WebSocket client connected: %s (%s) to session %s", userID, userType, sessionID)
+	// Replay event yang terlewat sebelum event live
+	if resumeFrom >= 0 {
+		lastSeq := w.replaySessionEvents(ctx, wsConn, resumeFrom)
+		wsConn.finishReplay(lastSeq)
+	}
+
+	wsConn.logger.Info("WebSocket client connected", "user_type", userType, "resume_from", resumeFrom)
+
+	// Heartbeat: ping dikirim oleh writePump, setiap pong (atau pesan apapun dari client)
+	// memperpanjang read deadline sehingga koneksi half-open akan gagal di ReadJSON
+	// dan dibersihkan lewat defer di atas
+	c.SetReadDeadline(time.Now().Add(w.config.WSPongWait))
+	c.SetPongHandler(func(string) error {
+		if wsConn.closing() {
+			return nil
+		}
+		wsConn.touch()
+		return c.SetReadDeadline(time.Now().Add(w.config.WSPongWait))
+	})
 
 	// Handle incoming messages
 	for {
 		var msg domain.WebSocketMessage
 		if err := c.ReadJSON(&msg); err != nil {
-			log.Printf("WebSocket read error for user %s: %v", userID, err)
+			wsConn.logger.Debug("WebSocket read error", "error", err)
 			break
 		}
+		// Setelah close frame terkirim, pesan client dibuang sampai handshake selesai
+		if wsConn.closing() {
+			continue
+		}
+		c.SetReadDeadline(time.Now().Add(w.config.WSPongWait))
+		wsConn.touch()
 
 		// Process message based on type
-		w.handleIncomingMessage(ctx, c, &msg, sessionID, userID, userType)
+		w.handleIncomingMessage(ctx, wsConn, &msg)
 	}
 
-	log.Printf("WebSocket client disconnected: %s (%s) from session %s", userID, userType, sessionID)
+	wsConn.logger.Info("WebSocket client disconnected", "user_type", userType)
 }
 
-func (w *WSManager) sendWelcomeMessage(c *websocket.Conn, sessionID, userID, userType string) {
+func (w *WSManager) sendWelcomeMessage(ctx context.Context, conn *WSConnection) {
+	// Seq terakhir session dipakai client sebagai titik awal resume_from
+	lastSeq, err := w.redisClient.GetSessionSeq(ctx, conn.SessionID)
+	if err != nil {
+		conn.logger.Error("Failed to get session sequence number", "error", err)
+	}
+
 	response := domain.WebSocketResponse{
 		Type:    "connection_established",
 		Success: true,
 		Data: map[string]interface{}{
-			"session_id": sessionID,
-			"user_id":    userID,
-			"user_type":  userType,
-			"timestamp":  time.Now().Format(time.RFC3339),
-			"message":    "Successfully connected to chat session",
+			"session_id":    conn.SessionID,
+			"user_id":       conn.UserID,
+			"user_type":     conn.UserType,
+			"connection_id": conn.ID,
+			"last_seq":      lastSeq,
+			"timestamp":     time.Now().Format(time.RFC3339),
+			"message":       "Successfully connected to chat session",
 		},
 	}
 
-	// Gunakan direct write karena ini masih dalam setup koneksi
-	if err := w.safeWriteToConn(c, response); err != nil {
-		log.Printf("Failed to send welcome message: %v", err)
+	if !conn.sendJSON(response) {
+		conn.logger.Warn("Failed to queue welcome message")
 	}
 }
 
+// sendErrorResponse menulis langsung ke socket, hanya dipakai sebelum koneksi terdaftar
 func (w *WSManager) sendErrorResponse(c *websocket.Conn, errorMsg string) {
 	response := domain.WebSocketResponse{
 		Type:    "error",
@@ -210,7 +469,7 @@ func (w *WSManager) sendErrorResponse(c *websocket.Conn, errorMsg string) {
 	}
 
 	if err := w.safeWriteToConn(c, response); err != nil {
-		log.Printf("Failed to send error response: %v", err)
+		w.logger.Warn("Failed to send error response", "error", err)
 	}
 }
 
@@ -218,14 +477,28 @@ func (w *WSManager) sendErrorResponse(c *websocket.Conn, errorMsg string) {
 func (w *WSManager) safeWriteToConn(c *websocket.Conn, message interface{}) error {
 	defer func() {
 		if r := recover(); r != nil {
-			log.Printf("Recovered from panic in safeWriteToConn: %v", r)
+			w.logger.Error("Recovered from panic in safeWriteToConn", "panic", r)
 		}
 	}()
 
 	return c.WriteJSON(message)
 }
 
-func (w *WSManager) handleIncomingMessage(ctx context.Context, c *websocket.Conn, msg *domain.WebSocketMessage, sessionID, userID, userType string) {
+func (w *WSManager) sendConnectionError(conn *WSConnection, errorMsg string) {
+	response := domain.WebSocketResponse{
+		Type:    "error",
+		Success: false,
+		Error:   errorMsg,
+	}
+
+	if !conn.sendJSON(response) {
+		conn.logger.Warn("Failed to queue error response")
+	}
+}
+
+func (w *WSManager) handleIncomingMessage(ctx context.Context, conn *WSConnection, msg *domain.WebSocketMessage) {
+	sessionID, userID, userType := conn.SessionID, conn.UserID, conn.UserType
+
 	switch msg.Type {
 	case "join_session":
 		// Send join confirmation
@@ -239,7 +512,7 @@ func (w *WSManager) handleIncomingMessage(ctx context.Context, c *websocket.Conn
 				"timestamp":  time.Now().Format(time.RFC3339),
 			},
 		}
-		w.safeWriteToConn(c, response)
+		conn.sendJSON(response)
 
 	case "typing_start", "agent_typing":
 		isTyping := true
@@ -252,13 +525,13 @@ func (w *WSManager) handleIncomingMessage(ctx context.Context, c *websocket.Conn
 				}
 			}
 		}
-		w.handleTypingIndicator(ctx, sessionID, userID, userType, isTyping)
+		w.handleTypingIndicator(ctx, conn, isTyping)
 
 	case "typing_stop":
-		w.handleTypingIndicator(ctx, sessionID, userID, userType, false)
+		w.handleTypingIndicator(ctx, conn, false)
 
 	case "send_message":
-		w.handleSendMessage(c, msg)
+		w.handleSendMessage(ctx, conn, msg)
 
 	case "ping":
 		// Respond to ping with pong
@@ -269,18 +542,23 @@ func (w *WSManager) handleIncomingMessage(ctx context.Context, c *websocket.Conn
 				"timestamp": time.Now().Format(time.RFC3339),
 			},
 		}
-		w.safeWriteToConn(c, response)
+		conn.sendJSON(response)
 
 	default:
-		log.Printf("Unknown message type: %s from user %s", msg.Type, userID)
-		w.sendErrorResponse(c, "Unknown message type: "+msg.Type)
+		conn.logger.Warn("Unknown message type", "type", msg.Type)
+		w.sendConnectionError(conn, "Unknown message type: "+msg.Type)
 	}
 }
 
-func (w *WSManager) handleTypingIndicator(ctx context.Context, sessionID, userID, userType string, isTyping bool) {
+func (w *WSManager) handleTypingIndicator(ctx context.Context, conn *WSConnection, isTyping bool) {
+	sessionID, userID, userType := conn.SessionID, conn.UserID, conn.UserType
+
+	// Event ID yang sama dipakai untuk broadcast lokal dan broker supaya seq-nya sama
+	eventID := uuid.New().String()
+
 	// Set typing status in Redis
 	if err := w.redisClient.SetUserTyping(ctx, sessionID, userID, isTyping); err != nil {
-		log.Printf("Failed to set typing status in Redis: %v", err)
+		conn.logger.Error("Failed to set typing status in Redis", "error", err)
 	}
 
 	// Broadcast typing status directly to WebSocket clients
@@ -294,17 +572,20 @@ func (w *WSManager) handleTypingIndicator(ctx context.Context, sessionID, userID
 			"timestamp":   time.Now().Format(time.RFC3339),
 		},
 	}
-	w.broadcastToSession(sessionID, typingWSMessage)
+	if err := w.broadcastToSession(ctx, sessionID, eventID, typingWSMessage); err != nil {
+		conn.logger.Error("Failed to broadcast typing indicator", "error", err)
+	}
 
-	// Also send typing status via Kafka for other services
+	// Also publish typing status for other instances and services
 	sessionUUID, err := uuid.Parse(sessionID)
 	if err != nil {
-		log.Printf("Invalid session ID format: %v", err)
+		conn.logger.Warn("Invalid session ID format", "error", err)
 		return
 	}
 
 	typingMsg := domain.TypingMessage{
 		Type:      "typing_indicator",
+		EventID:   eventID,
 		SessionID: sessionUUID,
 		UserID:    userID,
 		UserType:  userType,
@@ -312,39 +593,172 @@ func (w *WSManager) handleTypingIndicator(ctx context.Context, sessionID, userID
 		Timestamp: time.Now(),
 	}
 
-	if err := w.kafkaProducer.SendMessage(ctx, typingMsg); err != nil {
-		log.Printf("Failed to send typing message to Kafka: %v", err)
+	if err := w.publishEvent(ctx, broker.EventTypingIndicator, eventID, sessionID, typingMsg); err != nil {
+		conn.logger.Error("Failed to publish typing indicator", "error", err)
 		// Don't return error, continue with WebSocket operation
 	}
 }
 
-func (w *WSManager) handleSendMessage(c *websocket.Conn, msg *domain.WebSocketMessage) {
-	// This would typically send message to backend via API
-	// For now, just log it and send confirmation
-	log.Printf("Message received from %s: %+v", msg.UserID, msg)
+// handleSendMessage memvalidasi pesan dari client, mem-publish ChatMessage ke broker, dan
+// baru mengirim ack message_sent setelah broker mengkonfirmasi. Pesan akan sampai ke
+// semua client (termasuk pengirim) sebagai new_message lewat subscriber broker dengan ID yang sama.
+// Jika client mengirim client_message_id, retry dengan ID yang sama mendapat ack yang
+// sama tanpa membuat ChatMessage baru.
+func (w *WSManager) handleSendMessage(ctx context.Context, conn *WSConnection, msg *domain.WebSocketMessage) {
+	clientMessageID := msg.ClientMessageID
+
+	// Root span pesan dari client, publish ke broker menjadi child-nya
+	ctx, span := tracing.Tracer().Start(ctx, "ws.send_message",
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			tracing.AttrSessionID.String(conn.SessionID),
+			tracing.AttrConnectionID.String(conn.ID),
+		))
+	defer span.End()
+
+	var req domain.SendMessageRequest
+	if err := decodeMessageData(msg.Data, &req); err != nil {
+		w.sendMessageFailed(conn, clientMessageID, "Invalid message payload")
+		return
+	}
+
+	if err := validateClientMessageID(clientMessageID); err != nil {
+		w.sendMessageFailed(conn, "", err.Error())
+		return
+	}
+	if err := validateSendMessageRequest(&req, conn.SessionID); err != nil {
+		w.sendMessageFailed(conn, clientMessageID, err.Error())
+		return
+	}
+
+	now := time.Now()
+	record := domain.ClientMessageRecord{MessageID: uuid.New(), CreatedAt: now, Status: domain.ClientMessagePending}
+
+	// Reservasi disimpan sebagai pending sampai publish berhasil, supaya retry yang datang
+	// selama publish berjalan tidak mendapat ack untuk pesan yang mungkin masih gagal
+	reserved := false
+	if clientMessageID != "" {
+		existing, ok, err := w.redisClient.ReserveClientMessage(ctx, conn.SessionID, conn.UserID, clientMessageID, record, pendingMessageTTL)
+		switch {
+		case err != nil:
+			// Redis bermasalah: tetap kirim pesan, de-duplikasi dilewati
+			conn.logger.Error("Failed to reserve client message ID", "client_message_id", clientMessageID, "error", err)
+		case !ok && !existing.IsSent():
+			conn.logger.Info("Duplicate send_message while original is still pending", "client_message_id", clientMessageID)
+			w.sendMessagePending(conn, clientMessageID, existing)
+			return
+		case !ok:
+			conn.logger.Info("Duplicate send_message, replaying ack", "client_message_id", clientMessageID)
+			w.sendMessageSent(conn, clientMessageID, existing, true)
+			return
+		default:
+			reserved = true
+		}
+	}
+
+	chatMsg := domain.ChatMessage{
+		ID:          record.MessageID,
+		SessionID:   req.SessionID,
+		SenderType:  conn.UserType,
+		Message:     req.Message,
+		MessageType: req.MessageType,
+		Attachments: req.Attachments,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	if senderID, err := uuid.Parse(conn.UserID); err == nil {
+		chatMsg.SenderID = &senderID
+	}
+
+	publishCtx, cancel := context.WithTimeout(ctx, sendMessageTimeout)
+	defer cancel()
+
+	span.SetAttributes(tracing.AttrEventID.String(chatMsg.ID.String()))
+	if err := w.publishEvent(publishCtx, broker.EventNewMessage, chatMsg.ID.String(), conn.SessionID, chatMsg); err != nil {
+		tracing.RecordError(span, err)
+		conn.logger.Error("Failed to publish chat message", "message_id", chatMsg.ID.String(), "error", err)
+		if reserved {
+			if err := w.redisClient.ReleaseClientMessage(ctx, conn.SessionID, conn.UserID, clientMessageID); err != nil {
+				conn.logger.Error("Failed to release client message ID", "client_message_id", clientMessageID, "error", err)
+			}
+		}
+		w.sendMessageFailed(conn, clientMessageID, "Failed to send message")
+		return
+	}
+
+	record.Status = domain.ClientMessageSent
+	if reserved {
+		if err := w.redisClient.ConfirmClientMessage(ctx, conn.SessionID, conn.UserID, clientMessageID, record, w.config.MessageDedupTTL); err != nil {
+			conn.logger.Error("Failed to confirm client message ID", "client_message_id", clientMessageID, "error", err)
+		}
+	}
+
+	w.sendMessageSent(conn, clientMessageID, &record, false)
+}
 
-	// Send confirmation back to sender
+// sendMessagePending membalas retry yang datang saat pesan aslinya masih di-publish.
+// Ini bukan ack: client harus mengirim ulang lagi nanti untuk mendapat message_sent.
+func (w *WSManager) sendMessagePending(conn *WSConnection, clientMessageID string, record *domain.ClientMessageRecord) {
 	response := domain.WebSocketResponse{
-		Type:    "message_sent",
-		Success: true,
+		Type:    "message_pending",
+		Success: false,
 		Data: map[string]interface{}{
-			"message_id": uuid.New().String(),
-			"timestamp":  time.Now().Format(time.RFC3339),
+			"client_message_id": clientMessageID,
+			"message_id":        record.MessageID.String(),
+			"session_id":        conn.SessionID,
 		},
 	}
 
-	if err := w.safeWriteToConn(c, response); err != nil {
-		log.Printf("Failed to send message confirmation: %v", err)
+	if !conn.sendJSON(response) {
+		conn.logger.Warn("Failed to queue message pending reply")
+	}
+}
+
+func (w *WSManager) sendMessageSent(conn *WSConnection, clientMessageID string, record *domain.ClientMessageRecord, duplicate bool) {
+	data := map[string]interface{}{
+		"message_id": record.MessageID.String(),
+		"session_id": conn.SessionID,
+		"timestamp":  record.CreatedAt.Format(time.RFC3339),
+		"duplicate":  duplicate,
+	}
+	if clientMessageID != "" {
+		data["client_message_id"] = clientMessageID
+	}
+
+	response := domain.WebSocketResponse{
+		Type:    "message_sent",
+		Success: true,
+		Data:    data,
+	}
+
+	if !conn.sendJSON(response) {
+		conn.logger.Warn("Failed to queue message confirmation")
+	}
+}
+
+func (w *WSManager) sendMessageFailed(conn *WSConnection, clientMessageID, errorMsg string) {
+	response := domain.WebSocketResponse{
+		Type:    "message_sent",
+		Success: false,
+		Error:   errorMsg,
+	}
+	if clientMessageID != "" {
+		response.Data = map[string]interface{}{"client_message_id": clientMessageID}
+	}
+
+	if !conn.sendJSON(response) {
+		conn.logger.Warn("Failed to queue message failure")
 	}
 }
 
 func (w *WSManager) broadcastConnectionStatusWithContext(sessionID, eventType, eventUserID string) {
 	ctx := context.Background()
+	eventID := uuid.New().String()
 
 	// Get connection status from Redis
-	status, err := w.redisClient.GetSessionUsers(ctx, sessionID)
+	status, err := w.redisClient.GetSessionUsers(ctx, sessionID, w.config.InstanceID)
 	if err != nil {
-		log.Printf("Failed to get session users: %v", err)
+		w.logger.Error("Failed to get session users", "session_id", sessionID, "error", err)
 		return
 	}
 
@@ -368,40 +782,116 @@ func (w *WSManager) broadcastConnectionStatusWithContext(sessionID, eventType, e
 		Type: "connection_status_update",
 		Data: messageData,
 	}
-	w.broadcastToSession(sessionID, connectionWSMessage)
+	if err := w.broadcastToSession(ctx, sessionID, eventID, connectionWSMessage); err != nil {
+		w.logger.Error("Failed to broadcast connection status", "session_id", sessionID, "error", err)
+	}
 
-	// Also send connection status via Kafka for other services
+	// Also publish connection status for other instances and services
 	sessionUUID, err := uuid.Parse(sessionID)
 	if err != nil {
-		log.Printf("Invalid session ID format: %v", err)
+		w.logger.Warn("Invalid session ID format", "session_id", sessionID, "error", err)
 		return
 	}
 
 	statusMsg := domain.ConnectionStatusMessage{
 		Type:             "connection_status",
+		EventID:          eventID,
 		SessionID:        sessionUUID,
 		ConnectionStatus: status,
 		Timestamp:        time.Now(),
 	}
 
-	if err := w.kafkaProducer.SendMessage(ctx, statusMsg); err != nil {
-		log.Printf("Failed to send connection status to Kafka: %v", err)
+	if err := w.publishEvent(ctx, broker.EventConnectionStatus, eventID, sessionID, statusMsg); err != nil {
+		w.logger.Error("Failed to publish connection status", "session_id", sessionID, "error", err)
 		// Don't return error, continue with WebSocket operation
 	}
 }
 
-// MessageHandler interface implementation for Kafka message processing
-func (w *WSManager) HandleNewMessage(msg domain.ChatMessage) {
-	// Recovery dari panic untuk mencegah crash service
+// publishEvent membungkus payload ke envelope lalu mem-publish-nya ke broker
+func (w *WSManager) publishEvent(ctx context.Context, eventType, eventID, sessionID string, payload interface{}) error {
+	event, err := broker.NewEvent(eventType, eventID, sessionID, payload)
+	if err != nil {
+		return err
+	}
+	return w.broker.Publish(ctx, event)
+}
+
+// RegisterEventHandlers mendaftarkan handler event bawaan ke registry. Tipe event yang
+// tidak dikenal diteruskan apa adanya ke client atau dibuang sesuai UNKNOWN_EVENT_POLICY.
+func (w *WSManager) RegisterEventHandlers(registry *broker.Registry) {
+	registry.Register(broker.EventNewMessage, broker.HandlerFunc(func(ctx context.Context, event *domain.EventEnvelope) error {
+		var msg domain.ChatMessage
+		if err := broker.DecodePayload(event, &msg); err != nil {
+			return err
+		}
+		return w.handleNewMessage(ctx, event.ID, msg)
+	}))
+	registry.Register(broker.EventTypingIndicator, broker.HandlerFunc(func(ctx context.Context, event *domain.EventEnvelope) error {
+		var msg domain.TypingMessage
+		if err := broker.DecodePayload(event, &msg); err != nil {
+			return err
+		}
+		if msg.EventID == "" {
+			msg.EventID = event.ID
+		}
+		return w.HandleTypingIndicator(ctx, msg)
+	}))
+	registry.Register(broker.EventConnectionStatus, broker.HandlerFunc(func(ctx context.Context, event *domain.EventEnvelope) error {
+		var msg domain.ConnectionStatusMessage
+		if err := broker.DecodePayload(event, &msg); err != nil {
+			return err
+		}
+		if msg.EventID == "" {
+			msg.EventID = event.ID
+		}
+		return w.HandleConnectionStatus(ctx, msg)
+	}))
+
+	if w.config.UnknownEventPolicy == config.UnknownEventPassthrough {
+		registry.HandleUnknown(broker.HandlerFunc(w.HandlePassthroughEvent))
+	}
+}
+
+// HandlePassthroughEvent mengirim event yang tidak punya handler khusus langsung ke
+// client session dengan type dan payload apa adanya
+func (w *WSManager) HandlePassthroughEvent(ctx context.Context, event *domain.EventEnvelope) error {
+	if event.SessionID == "" {
+		w.logger.Warn("Dropping event without session ID", "event_type", event.Type, "event_id", event.ID)
+		return nil
+	}
+
+	wsMessage := domain.WebSocketResponse{
+		Type:    event.Type,
+		Success: true,
+		Data:    event.Payload,
+	}
+
+	if err := w.broadcastToSession(ctx, event.SessionID, event.ID, wsMessage); err != nil {
+		return err
+	}
+	w.logger.Debug("Passed through event to session", "session_id", event.SessionID,
+		"event_type", event.Type, "event_id", event.ID, logging.KeyPayload, string(event.Payload))
+	return nil
+}
+
+// Handler event bawaan dari broker
+func (w *WSManager) HandleNewMessage(ctx context.Context, msg domain.ChatMessage) error {
+	return w.handleNewMessage(ctx, "", msg)
+}
+
+// handleNewMessage memakai ID pesan sebagai event ID, atau eventID jika pesan tidak punya ID
+func (w *WSManager) handleNewMessage(ctx context.Context, eventID string, msg domain.ChatMessage) (err error) {
+	// Recovery dari panic untuk mencegah crash service, panic dilaporkan sebagai error
 	defer func() {
 		if r := recover(); r != nil {
-			log.Printf("Recovered from panic in HandleNewMessage: %v", r)
+			w.logger.Error("Recovered from panic in HandleNewMessage", "panic", r)
+			err = fmt.Errorf("panic in HandleNewMessage: %v", r)
 		}
 	}()
 
 	sessionID := msg.SessionID.String()
-	log.Printf("HandleNewMessage: SessionID=%s, SenderType=%s, Message=%s",
-		sessionID, msg.SenderType, msg.Message)
+	w.logger.Debug("Handling new message", "session_id", sessionID, "message_id", msg.ID.String(),
+		"sender_type", msg.SenderType, logging.KeyMessage, msg.Message, logging.KeyAttachments, msg.Attachments)
 
 	// Broadcast new message to WebSocket clients in the session
 	wsMessage := domain.WebSocketResponse{
@@ -418,15 +908,22 @@ func (w *WSManager) HandleNewMessage(msg domain.ChatMessage) {
 		},
 	}
 
-	w.broadcastToSession(sessionID, wsMessage)
-	log.Printf("Broadcasted new message to session %s", sessionID)
+	if msg.ID != uuid.Nil {
+		eventID = msg.ID.String()
+	}
+	if err := w.broadcastToSession(ctx, sessionID, eventID, wsMessage); err != nil {
+		return err
+	}
+	w.logger.Debug("Broadcasted new message", "session_id", sessionID, "message_id", msg.ID.String())
+	return nil
 }
 
-func (w *WSManager) HandleTypingIndicator(msg domain.TypingMessage) {
-	// Recovery dari panic untuk mencegah crash service
+func (w *WSManager) HandleTypingIndicator(ctx context.Context, msg domain.TypingMessage) (err error) {
+	// Recovery dari panic untuk mencegah crash service, panic dilaporkan sebagai error
 	defer func() {
 		if r := recover(); r != nil {
-			log.Printf("Recovered from panic in HandleTypingIndicator: %v", r)
+			w.logger.Error("Recovered from panic in HandleTypingIndicator", "panic", r)
+			err = fmt.Errorf("panic in HandleTypingIndicator: %v", r)
 		}
 	}()
 
@@ -444,16 +941,20 @@ func (w *WSManager) HandleTypingIndicator(msg domain.TypingMessage) {
 		},
 	}
 
-	w.broadcastToSession(sessionID, wsMessage)
-	log.Printf("Broadcasted typing indicator to session %s: %s is %s",
-		sessionID, msg.UserID, map[bool]string{true: "typing", false: "not typing"}[msg.IsTyping])
+	if err := w.broadcastToSession(ctx, sessionID, msg.EventID, wsMessage); err != nil {
+		return err
+	}
+	w.logger.Debug("Broadcasted typing indicator", "session_id", sessionID,
+		"user_id", msg.UserID, "is_typing", msg.IsTyping)
+	return nil
 }
 
-func (w *WSManager) HandleConnectionStatus(msg domain.ConnectionStatusMessage) {
-	// Recovery dari panic untuk mencegah crash service
+func (w *WSManager) HandleConnectionStatus(ctx context.Context, msg domain.ConnectionStatusMessage) (err error) {
+	// Recovery dari panic untuk mencegah crash service, panic dilaporkan sebagai error
 	defer func() {
 		if r := recover(); r != nil {
-			log.Printf("Recovered from panic in HandleConnectionStatus: %v", r)
+			w.logger.Error("Recovered from panic in HandleConnectionStatus", "panic", r)
+			err = fmt.Errorf("panic in HandleConnectionStatus: %v", r)
 		}
 	}()
 
@@ -469,8 +970,11 @@ func (w *WSManager) HandleConnectionStatus(msg domain.ConnectionStatusMessage) {
 		},
 	}
 
-	w.broadcastToSession(sessionID, wsMessage)
-	log.Printf("Broadcasted connection status to session %s", sessionID)
+	if err := w.broadcastToSession(ctx, sessionID, msg.EventID, wsMessage); err != nil {
+		return err
+	}
+	w.logger.Debug("Broadcasted connection status", "session_id", sessionID)
+	return nil
 }
 
 // GetActiveConnections returns the current active connections for monitoring
@@ -496,17 +1000,88 @@ func (w *WSManager) GetSessionConnectionCount(sessionID string) int {
 	return 0
 }
 
-// safeWriteJSON writes JSON to WebSocket connection with mutex protection and panic recovery
-func (conn *WSConnection) safeWriteJSON(message interface{}) error {
-	conn.writeMux.Lock()
-	defer conn.writeMux.Unlock()
+// ListSessions mengembalikan ringkasan semua session yang punya koneksi di instance ini
+func (w *WSManager) ListSessions() []domain.SessionSummary {
+	w.mutex.RLock()
+	defer w.mutex.RUnlock()
 
-	// Recovery dari panic untuk mencegah crash
-	defer func() {
-		if r := recover(); r != nil {
-			log.Printf("Recovered from panic in safeWriteJSON for user %s: %v", conn.UserID, r)
+	sessions := make([]domain.SessionSummary, 0, len(w.connections))
+	for sessionID, connections := range w.connections {
+		summary := domain.SessionSummary{
+			SessionID:   sessionID,
+			Connections: len(connections),
+			ByUserType:  make(map[string]int),
 		}
-	}()
+		users := make(map[string]struct{})
+		for _, conn := range connections {
+			users[conn.UserID] = struct{}{}
+			summary.ByUserType[conn.UserType]++
+		}
+		summary.Users = len(users)
+		sessions = append(sessions, summary)
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].SessionID < sessions[j].SessionID
+	})
+	return sessions
+}
+
+// GetSessionConnections mengembalikan detail koneksi sebuah session di instance ini
+func (w *WSManager) GetSessionConnections(sessionID string) []domain.ConnectionInfo {
+	w.mutex.RLock()
+	defer w.mutex.RUnlock()
+
+	connections := w.connections[sessionID]
+	result := make([]domain.ConnectionInfo, 0, len(connections))
+	for _, conn := range connections {
+		result = append(result, conn.Info())
+	}
+	return result
+}
+
+// InstanceInfo mengembalikan statistik instance ini untuk registry di Redis
+func (w *WSManager) InstanceInfo() domain.InstanceInfo {
+	w.mutex.RLock()
+	defer w.mutex.RUnlock()
+
+	info := domain.InstanceInfo{
+		InstanceID:    w.config.InstanceID,
+		BrokerType:    w.config.BrokerType,
+		StartedAt:     w.startedAt,
+		LastHeartbeat: time.Now(),
+		Sessions:      len(w.connections),
+		ByUserType:    make(map[string]int),
+	}
+	for _, connections := range w.connections {
+		info.Connections += len(connections)
+		for _, conn := range connections {
+			info.ByUserType[conn.UserType]++
+		}
+	}
+	return info
+}
 
-	return conn.Conn.WriteJSON(message)
+// RunInstanceHeartbeat mendaftarkan instance ini ke Redis setiap INSTANCE_HEARTBEAT_INTERVAL
+// sampai ctx selesai, lalu menghapusnya dari registry
+func (w *WSManager) RunInstanceHeartbeat(ctx context.Context) {
+	ticker := time.NewTicker(w.config.InstanceHeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := w.redisClient.RegisterInstance(ctx, w.InstanceInfo(), w.config.InstanceTTL); err != nil && ctx.Err() == nil {
+			w.logger.Warn("Failed to register instance", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			deregisterCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := w.redisClient.DeregisterInstance(deregisterCtx, w.config.InstanceID); err != nil {
+				w.logger.Warn("Failed to deregister instance", "error", err)
+			}
+			return
+		case <-ticker.C:
+		}
+	}
 }
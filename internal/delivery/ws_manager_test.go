@@ -0,0 +1,447 @@
+package delivery
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	"livechat-ws/internal/config"
+	"livechat-ws/internal/domain"
+	"livechat-ws/internal/infrastructure/broker"
+	"livechat-ws/internal/infrastructure/redis"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gofiber/websocket/v2"
+	"github.com/google/uuid"
+)
+
+// fanoutHub meniru Kafka dalam mode broadcast: setiap event yang di-publish diterima
+// oleh semua instance, dan tiap instance membuang echo miliknya sendiri
+type fanoutHub struct {
+	mutex sync.RWMutex
+	subs  []hubSubscription
+}
+
+type hubSubscription struct {
+	instanceID string
+	topics     map[string]bool
+	handler    broker.Handler
+}
+
+// hubBroker adalah broker.Broker satu instance yang terhubung ke fanoutHub
+type hubBroker struct {
+	hub        *fanoutHub
+	instanceID string
+	// format encoding record, kosong berarti envelope
+	format string
+}
+
+func (b *hubBroker) Publish(ctx context.Context, event *domain.EventEnvelope) error {
+	format := b.format
+	if format == "" {
+		format = broker.FormatEnvelope
+	}
+	value, err := broker.Codec{Format: format}.Encode(broker.WithOrigin(event, b.instanceID))
+	if err != nil {
+		return err
+	}
+	topic := broker.TopicForEvent(event)
+
+	b.hub.mutex.RLock()
+	defer b.hub.mutex.RUnlock()
+
+	for _, sub := range b.hub.subs {
+		if !sub.topics[topic] {
+			continue
+		}
+		decoded, err := broker.Decode(topic, value)
+		if err != nil {
+			return err
+		}
+		if broker.IsOwnEcho(decoded, sub.instanceID) {
+			continue
+		}
+		if err := sub.handler.HandleEvent(ctx, decoded); err != nil {
+			return fmt.Errorf("instance %s: %w", sub.instanceID, err)
+		}
+	}
+	return nil
+}
+
+func (b *hubBroker) Subscribe(ctx context.Context, topics []string, handler broker.Handler) error {
+	sub := hubSubscription{instanceID: b.instanceID, topics: make(map[string]bool), handler: handler}
+	for _, topic := range topics {
+		sub.topics[topic] = true
+	}
+
+	b.hub.mutex.Lock()
+	defer b.hub.mutex.Unlock()
+	b.hub.subs = append(b.hub.subs, sub)
+	return nil
+}
+
+func (b *hubBroker) Close() error {
+	return nil
+}
+
+func newTestConfig(instanceID string) *config.Config {
+	return &config.Config{
+		InstanceID:            instanceID,
+		BrokerType:            "memory",
+		WSSendQueueSize:       16,
+		WSSlowConsumerPolicy:  SlowConsumerDropOldest,
+		SessionEventLogSize:   100,
+		SessionEventRetention: time.Hour,
+		SessionEventDedupTTL:  time.Minute,
+		UnknownEventPolicy:    config.UnknownEventDrop,
+	}
+}
+
+func newTestRedis(t *testing.T) (*redis.RedisClient, *miniredis.Miniredis) {
+	t.Helper()
+
+	server := miniredis.RunT(t)
+	client := redis.NewRedisClient(server.Host(), server.Port(), "")
+	t.Cleanup(func() { client.Close() })
+	return client, server
+}
+
+func newTestLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+// receive mengambil pesan berikutnya dari antrian kirim koneksi tanpa socket
+func receive(t *testing.T, conn *WSConnection) domain.WebSocketResponse {
+	t.Helper()
+
+	select {
+	case msg := <-conn.send:
+		var response domain.WebSocketResponse
+		if err := json.Unmarshal(msg.data, &response); err != nil {
+			t.Fatalf("invalid message on %s: %v", conn.ID, err)
+		}
+		return response
+	case <-time.After(time.Second):
+		t.Fatalf("no message delivered to %s", conn.ID)
+		return domain.WebSocketResponse{}
+	}
+}
+
+func TestEveryInstanceReceivesEverySessionEvent(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		// withIDs false meniru record legacy dari service lama yang tidak membawa ID
+		withIDs bool
+	}{
+		{name: "envelope", format: broker.FormatEnvelope, withIDs: true},
+		{name: "legacy without ids", format: broker.FormatLegacy},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			redisClient, _ := newTestRedis(t)
+			logger := newTestLogger()
+			hub := &fanoutHub{}
+			sessionID := uuid.New()
+			ctx := context.Background()
+
+			var connections []*WSConnection
+			for i := 0; i < 3; i++ {
+				instanceID := fmt.Sprintf("instance-%d", i)
+				manager := NewWSManager(newTestConfig(instanceID), &hubBroker{hub: hub, instanceID: instanceID}, redisClient, logger)
+
+				registry := broker.NewRegistry(logger)
+				manager.RegisterEventHandlers(registry)
+				if err := manager.broker.Subscribe(ctx, broker.DefaultTopics, registry); err != nil {
+					t.Fatalf("subscribe %s: %v", instanceID, err)
+				}
+
+				conn := newWSConnection(instanceID+"-conn", nil, sessionID.String(), fmt.Sprintf("user-%d", i), "customer",
+					clientInfo{}, 16, SlowConsumerDropOldest, logger)
+				manager.addConnection(sessionID.String(), conn)
+				connections = append(connections, conn)
+			}
+
+			// Event dikirim oleh service lain, bukan salah satu instance WebSocket
+			publisher := &hubBroker{hub: hub, instanceID: "chat-api", format: tt.format}
+			now := time.Now()
+			messageID := uuid.Nil
+			if tt.withIDs {
+				messageID = uuid.New()
+			}
+			events := []struct {
+				eventType string
+				wsType    string
+				payload   interface{}
+			}{
+				{broker.EventNewMessage, "new_message", domain.ChatMessage{
+					ID: messageID, SessionID: sessionID, SenderType: "agent", Message: "hello", MessageType: "text", CreatedAt: now,
+				}},
+				{broker.EventTypingIndicator, "typing_indicator", domain.TypingMessage{
+					Type: broker.EventTypingIndicator, SessionID: sessionID, UserID: "agent-1", UserType: "agent", IsTyping: true, Timestamp: now,
+				}},
+				{broker.EventConnectionStatus, "connection_status_update", domain.ConnectionStatusMessage{
+					Type: broker.EventConnectionStatus, SessionID: sessionID, ConnectionStatus: map[string]interface{}{"agent_connected": true}, Timestamp: now,
+				}},
+			}
+
+			var lastSeq int64
+			for _, event := range events {
+				eventID := ""
+				if tt.withIDs {
+					eventID = uuid.NewString()
+				}
+				envelope, err := broker.NewEvent(event.eventType, eventID, sessionID.String(), event.payload)
+				if err != nil {
+					t.Fatalf("build %s: %v", event.eventType, err)
+				}
+				if err := publisher.Publish(ctx, envelope); err != nil {
+					t.Fatalf("publish %s: %v", event.eventType, err)
+				}
+
+				// Semua instance harus mengirim event yang sama dengan seq yang sama
+				var seq int64
+				for _, conn := range connections {
+					response := receive(t, conn)
+					if response.Type != event.wsType {
+						t.Errorf("%s: got %q, want %q", conn.ID, response.Type, event.wsType)
+					}
+					if response.Seq == 0 {
+						t.Errorf("%s: %s delivered without seq", conn.ID, event.wsType)
+					}
+					if seq == 0 {
+						seq = response.Seq
+					} else if response.Seq != seq {
+						t.Errorf("%s: %s got seq %d, other instances got %d", conn.ID, event.wsType, response.Seq, seq)
+					}
+				}
+				if seq != lastSeq+1 {
+					t.Errorf("%s: got seq %d, want %d", event.wsType, seq, lastSeq+1)
+				}
+				lastSeq = seq
+			}
+		})
+	}
+}
+
+func TestBroadcastWithoutSeqWhenSessionLogUnavailable(t *testing.T) {
+	redisClient, server := newTestRedis(t)
+	logger := newTestLogger()
+	sessionID := uuid.New()
+
+	manager := NewWSManager(newTestConfig("instance-0"), &hubBroker{hub: &fanoutHub{}, instanceID: "instance-0"}, redisClient, logger)
+	conn := newWSConnection("conn", nil, sessionID.String(), "user-0", "customer", clientInfo{}, 16, SlowConsumerDropOldest, logger)
+	manager.addConnection(sessionID.String(), conn)
+
+	server.Close()
+
+	err := manager.HandleNewMessage(context.Background(), domain.ChatMessage{
+		ID: uuid.New(), SessionID: sessionID, SenderType: "agent", Message: "hello", CreatedAt: time.Now(),
+	})
+	if err != nil {
+		t.Fatalf("broadcast failed while Redis is down: %v", err)
+	}
+
+	response := receive(t, conn)
+	if response.Type != "new_message" {
+		t.Fatalf("got %q, want new_message", response.Type)
+	}
+	if response.Seq != 0 {
+		t.Fatalf("got seq %d, want no seq", response.Seq)
+	}
+}
+
+// gatedBroker menahan setiap Publish sampai test mengirim hasilnya lewat release
+type gatedBroker struct {
+	started chan struct{}
+	release chan error
+}
+
+func (b *gatedBroker) Publish(ctx context.Context, event *domain.EventEnvelope) error {
+	b.started <- struct{}{}
+	return <-b.release
+}
+
+func (b *gatedBroker) Subscribe(ctx context.Context, topics []string, handler broker.Handler) error {
+	return nil
+}
+
+func (b *gatedBroker) Close() error {
+	return nil
+}
+
+func TestSendMessageRetryIsNotAckedWhilePublishPending(t *testing.T) {
+	redisClient, _ := newTestRedis(t)
+	logger := newTestLogger()
+	sessionID := uuid.New().String()
+	gate := &gatedBroker{started: make(chan struct{}), release: make(chan error)}
+
+	cfg := newTestConfig("instance-0")
+	cfg.MessageDedupTTL = time.Hour
+	manager := NewWSManager(cfg, gate, redisClient, logger)
+	conn := newWSConnection("conn", nil, sessionID, "user-0", "customer", clientInfo{}, 16, SlowConsumerDropOldest, logger)
+
+	send := func(conn *WSConnection) <-chan struct{} {
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			manager.handleSendMessage(context.Background(), conn, &domain.WebSocketMessage{
+				Type:            "send_message",
+				ClientMessageID: "m1",
+				Data:            map[string]interface{}{"message": "hello"},
+			})
+		}()
+		return done
+	}
+
+	// Retry saat publish pertama masih berjalan tidak boleh mendapat ack
+	first := send(conn)
+	<-gate.started
+	<-send(conn)
+	if response := receive(t, conn); response.Type != "message_pending" {
+		t.Fatalf("retry during publish: got %q, want message_pending", response.Type)
+	}
+
+	// Publish gagal, reservasi dilepas sehingga retry berikutnya diproses sebagai pesan baru
+	gate.release <- errors.New("broker unavailable")
+	<-first
+	if response := receive(t, conn); response.Type != "message_sent" || response.Success {
+		t.Fatalf("failed publish: got %q success=%v", response.Type, response.Success)
+	}
+
+	retry := send(conn)
+	<-gate.started
+	gate.release <- nil
+	<-retry
+	acked := receive(t, conn)
+	if acked.Type != "message_sent" || !acked.Success {
+		t.Fatalf("retry after failure: got %q success=%v", acked.Type, acked.Success)
+	}
+
+	// Setelah publish berhasil, retry mendapat ack yang sama
+	<-send(conn)
+	duplicate := receive(t, conn)
+	ackData := acked.Data.(map[string]interface{})
+	duplicateData := duplicate.Data.(map[string]interface{})
+	if !duplicate.Success || duplicateData["duplicate"] != true || duplicateData["message_id"] != ackData["message_id"] {
+		t.Fatalf("duplicate ack: got %+v, want message_id %v", duplicate, ackData["message_id"])
+	}
+
+	// client_message_id yang sama dari user lain adalah pesan berbeda
+	other := newWSConnection("other", nil, sessionID, "user-1", "agent", clientInfo{}, 16, SlowConsumerDropOldest, logger)
+	otherDone := send(other)
+	<-gate.started
+	gate.release <- nil
+	<-otherDone
+	otherAck := receive(t, other)
+	otherData := otherAck.Data.(map[string]interface{})
+	if !otherAck.Success || otherData["duplicate"] == true || otherData["message_id"] == ackData["message_id"] {
+		t.Fatalf("other user's message treated as duplicate: %+v", otherAck)
+	}
+}
+
+func TestReplayPagesWholeLogAndSkipsTyping(t *testing.T) {
+	redisClient, _ := newTestRedis(t)
+	logger := newTestLogger()
+	sessionID := uuid.New()
+	ctx := context.Background()
+
+	// Antrian kirim jauh lebih kecil dari jumlah event di log
+	cfg := newTestConfig("instance-0")
+	cfg.WSSendQueueSize = 4
+	cfg.WSSlowConsumerPolicy = SlowConsumerDisconnect
+	manager := NewWSManager(cfg, &hubBroker{hub: &fanoutHub{}, instanceID: "instance-0"}, redisClient, logger)
+
+	var wantSeqs []int64
+	for i := 1; i <= 25; i++ {
+		message := domain.WebSocketResponse{Type: "new_message", Data: map[string]interface{}{"n": i}}
+		if i%5 == 0 {
+			message = domain.WebSocketResponse{Type: "typing_indicator", Data: map[string]interface{}{"is_typing": true}}
+		} else if i > 10 {
+			wantSeqs = append(wantSeqs, int64(i))
+		}
+		if err := manager.broadcastToSession(ctx, sessionID.String(), "", message); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	conn := newWSConnection("conn", nil, sessionID.String(), "user-0", "customer", clientInfo{}, cfg.WSSendQueueSize, cfg.WSSlowConsumerPolicy, logger)
+	conn.beginReplay()
+
+	// Event live yang masuk selama replay dikirim setelahnya
+	live := outboundMessage{data: []byte(`{"type":"typing_indicator","seq":26}`), seq: 26, typing: true}
+	conn.deliver(live)
+
+	// Pengganti writePump yang mengosongkan antrian
+	received := make(chan domain.WebSocketResponse, 64)
+	go func() {
+		for {
+			select {
+			case msg := <-conn.send:
+				var response domain.WebSocketResponse
+				if err := json.Unmarshal(msg.data, &response); err == nil {
+					received <- response
+				}
+			case <-conn.done:
+				return
+			}
+		}
+	}()
+	defer conn.closeWithCode(websocket.CloseNormalClosure, "")
+
+	lastSeq := manager.replaySessionEvents(ctx, conn, 10)
+	conn.finishReplay(lastSeq)
+	if lastSeq != 24 {
+		t.Fatalf("got last replayed seq %d, want 24", lastSeq)
+	}
+	if conn.closing() {
+		t.Fatal("replay triggered the slow consumer policy")
+	}
+
+	for _, want := range wantSeqs {
+		select {
+		case response := <-received:
+			if response.Type != "new_message" || response.Seq != want {
+				t.Fatalf("got %s seq %d, want new_message seq %d", response.Type, response.Seq, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("seq %d not replayed", want)
+		}
+	}
+	select {
+	case response := <-received:
+		if response.Type != "typing_indicator" || response.Seq != 26 {
+			t.Fatalf("got %s seq %d after replay, want live typing_indicator seq 26", response.Type, response.Seq)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("live event held during replay not delivered")
+	}
+}
+
+func TestReplayRequiresResyncWhenLogIsIncomplete(t *testing.T) {
+	redisClient, _ := newTestRedis(t)
+	logger := newTestLogger()
+	sessionID := uuid.New()
+	ctx := context.Background()
+
+	manager := NewWSManager(newTestConfig("instance-0"), &hubBroker{hub: &fanoutHub{}, instanceID: "instance-0"}, redisClient, logger)
+	if err := manager.broadcastToSession(ctx, sessionID.String(), "", domain.WebSocketResponse{Type: "new_message"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// resume_from lebih besar dari seq session: log sudah expired/reset
+	conn := newWSConnection("conn", nil, sessionID.String(), "user-0", "customer", clientInfo{}, 16, SlowConsumerDropOldest, logger)
+	if lastSeq := manager.replaySessionEvents(ctx, conn, 42); lastSeq != 0 {
+		t.Fatalf("got last replayed seq %d, want 0", lastSeq)
+	}
+	if response := receive(t, conn); response.Type != "resync_required" {
+		t.Fatalf("got %q, want resync_required", response.Type)
+	}
+}
@@ -20,16 +20,18 @@ type SendMessageResponse struct {
 }
 
 type WebSocketMessage struct {
-	Type      string      `json:"type"`
-	SessionID uuid.UUID   `json:"session_id"`
-	UserID    string      `json:"user_id"`
-	UserType  string      `json:"user_type"`
-	Data      interface{} `json:"data"`
-	Timestamp time.Time   `json:"timestamp"`
+	Type            string      `json:"type"`
+	ClientMessageID string      `json:"client_message_id,omitempty"`
+	SessionID       uuid.UUID   `json:"session_id"`
+	UserID          string      `json:"user_id"`
+	UserType        string      `json:"user_type"`
+	Data            interface{} `json:"data"`
+	Timestamp       time.Time   `json:"timestamp"`
 }
 
 type WebSocketResponse struct {
 	Type    string      `json:"type"`
+	Seq     int64       `json:"seq,omitempty"` // Nomor urut per session untuk event broadcast
 	Success bool        `json:"success"`
 	Data    interface{} `json:"data"`
 	Error   string      `json:"error,omitempty"`
@@ -45,3 +47,86 @@ type ConnectionStatusResponse struct {
 	TotalCustomer     int  `json:"total_customer"`
 	TotalAgent        int  `json:"total_agent"`
 }
+
+type ConnectionTicket struct {
+	UserID    string    `json:"user_id"`
+	UserType  string    `json:"user_type"`
+	SessionID string    `json:"session_id"`
+	IssuedAt  time.Time `json:"issued_at"`
+}
+
+type ConnectionTicketResponse struct {
+	Ticket    string    `json:"ticket"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// SessionEvent adalah event broadcast yang tersimpan di event log session.
+// Payload berisi WebSocketResponse tanpa seq.
+type SessionEvent struct {
+	Seq     int64
+	Payload []byte
+}
+
+// Status ClientMessageRecord
+const (
+	ClientMessagePending = "pending" // publish masih berjalan, belum boleh di-ack
+	ClientMessageSent    = "sent"    // publish sudah dikonfirmasi broker
+)
+
+// ClientMessageRecord menyimpan hasil send_message untuk sebuah client_message_id,
+// dipakai untuk membalas retry dengan ack yang sama
+type ClientMessageRecord struct {
+	MessageID uuid.UUID `json:"message_id"`
+	CreatedAt time.Time `json:"created_at"`
+	// Status kosong berasal dari record lama yang selalu disimpan setelah publish berhasil
+	Status string `json:"status,omitempty"`
+}
+
+// IsSent mengembalikan true jika pesan sudah di-publish dan retry boleh mendapat ack
+func (r ClientMessageRecord) IsSent() bool {
+	return r.Status == "" || r.Status == ClientMessageSent
+}
+
+// DeadLetterEntry adalah satu record di dead-letter topic Kafka
+type DeadLetterEntry struct {
+	Partition         int       `json:"partition"`
+	Offset            int64     `json:"offset"`
+	OriginalTopic     string    `json:"original_topic"`
+	OriginalPartition int       `json:"original_partition"`
+	OriginalOffset    int64     `json:"original_offset"`
+	Error             string    `json:"error"`
+	FailedAt          time.Time `json:"failed_at"`
+	Key               string    `json:"key,omitempty"`
+	Value             string    `json:"value"`
+}
+
+// ConnectionInfo adalah snapshot satu koneksi WebSocket untuk admin API
+type ConnectionInfo struct {
+	ConnectionID string    `json:"connection_id"`
+	SessionID    string    `json:"session_id"`
+	UserID       string    `json:"user_id"`
+	UserType     string    `json:"user_type"`
+	ConnectedAt  time.Time `json:"connected_at"`
+	LastActivity time.Time `json:"last_activity"`
+	RemoteIP     string    `json:"remote_ip"`
+	UserAgent    string    `json:"user_agent"`
+}
+
+// SessionSummary adalah ringkasan satu session yang punya koneksi di instance ini
+type SessionSummary struct {
+	SessionID   string         `json:"session_id"`
+	Connections int            `json:"connections"`
+	Users       int            `json:"users"`
+	ByUserType  map[string]int `json:"by_user_type"`
+}
+
+// InstanceInfo adalah data yang didaftarkan setiap instance ke Redis lewat heartbeat
+type InstanceInfo struct {
+	InstanceID    string         `json:"instance_id"`
+	BrokerType    string         `json:"broker_type"`
+	StartedAt     time.Time      `json:"started_at"`
+	LastHeartbeat time.Time      `json:"last_heartbeat"`
+	Connections   int            `json:"connections"`
+	Sessions      int            `json:"sessions"`
+	ByUserType    map[string]int `json:"by_user_type"`
+}
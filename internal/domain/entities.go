@@ -1,6 +1,7 @@
 package domain
 
 import (
+	"encoding/json"
 	"time"
 
 	"github.com/google/uuid"
@@ -21,6 +22,7 @@ type ChatMessage struct {
 
 type TypingMessage struct {
 	Type      string    `json:"type"`
+	EventID   string    `json:"event_id,omitempty"`
 	SessionID uuid.UUID `json:"session_id"`
 	UserID    string    `json:"user_id"`
 	UserType  string    `json:"user_type"`
@@ -39,7 +41,20 @@ type OnlineStatusMessage struct {
 
 type ConnectionStatusMessage struct {
 	Type             string                 `json:"type"`
+	EventID          string                 `json:"event_id,omitempty"`
 	SessionID        uuid.UUID              `json:"session_id"`
 	ConnectionStatus map[string]interface{} `json:"connection_status"`
 	Timestamp        time.Time              `json:"timestamp"`
 }
+
+// EventEnvelope adalah format event di broker. Payload berisi data sesuai Type dan
+// Version, misalnya ChatMessage untuk new_message.
+type EventEnvelope struct {
+	ID        string            `json:"id"`
+	Type      string            `json:"type"`
+	Version   int               `json:"version"`
+	SessionID string            `json:"session_id"`
+	Payload   json.RawMessage   `json:"payload"`
+	Metadata  map[string]string `json:"metadata,omitempty"`
+	Timestamp time.Time         `json:"timestamp"`
+}
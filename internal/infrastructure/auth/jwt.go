@@ -0,0 +1,119 @@
+package auth
+
+import (
+	"crypto/rsa"
+	"errors"
+	"fmt"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	ErrMissingToken  = errors.New("missing token")
+	ErrInvalidToken  = errors.New("invalid token")
+	ErrInvalidClaims = errors.New("token is missing required claims")
+)
+
+// Claims adalah payload JWT yang dipakai untuk koneksi WebSocket.
+// SessionIDs berisi daftar session yang boleh diakses, "*" berarti semua session.
+type Claims struct {
+	UserID     string   `json:"user_id"`
+	UserType   string   `json:"user_type"`
+	SessionIDs []string `json:"session_ids"`
+	jwt.RegisteredClaims
+}
+
+// CanAccessSession returns true if the claims allow joining the given session
+func (c *Claims) CanAccessSession(sessionID string) bool {
+	for _, allowed := range c.SessionIDs {
+		if allowed == "*" || allowed == sessionID {
+			return true
+		}
+	}
+	return false
+}
+
+type JWTValidator struct {
+	secret  []byte
+	rsaKeys map[string]*rsa.PublicKey
+	parser  *jwt.Parser
+}
+
+// NewJWTValidator membuat validator untuk HS256 (shared secret) dan/atau
+// RS256 (public key dari file JWKS lokal). Minimal salah satu harus diisi.
+func NewJWTValidator(secret, jwksFile, issuer, audience string) (*JWTValidator, error) {
+	v := &JWTValidator{}
+	var methods []string
+
+	if secret != "" {
+		v.secret = []byte(secret)
+		methods = append(methods, jwt.SigningMethodHS256.Alg())
+	}
+
+	if jwksFile != "" {
+		keys, err := loadJWKSFile(jwksFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load JWKS file %s: %w", jwksFile, err)
+		}
+		v.rsaKeys = keys
+		methods = append(methods, jwt.SigningMethodRS256.Alg())
+	}
+
+	if len(methods) == 0 {
+		return nil, errors.New("no JWT verification key configured")
+	}
+
+	opts := []jwt.ParserOption{jwt.WithValidMethods(methods), jwt.WithExpirationRequired()}
+	if issuer != "" {
+		opts = append(opts, jwt.WithIssuer(issuer))
+	}
+	if audience != "" {
+		opts = append(opts, jwt.WithAudience(audience))
+	}
+	v.parser = jwt.NewParser(opts...)
+
+	return v, nil
+}
+
+// Validate parses and verifies the token and returns its claims
+func (v *JWTValidator) Validate(tokenString string) (*Claims, error) {
+	if tokenString == "" {
+		return nil, ErrMissingToken
+	}
+
+	claims := &Claims{}
+	if _, err := v.parser.ParseWithClaims(tokenString, claims, v.keyFunc); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	// user_id boleh dikirim lewat claim "sub"
+	if claims.UserID == "" {
+		claims.UserID = claims.Subject
+	}
+	if claims.UserID == "" || claims.UserType == "" {
+		return nil, ErrInvalidClaims
+	}
+
+	return claims, nil
+}
+
+func (v *JWTValidator) keyFunc(token *jwt.Token) (interface{}, error) {
+	switch token.Method.Alg() {
+	case jwt.SigningMethodHS256.Alg():
+		return v.secret, nil
+	case jwt.SigningMethodRS256.Alg():
+		kid, _ := token.Header["kid"].(string)
+		if key, exists := v.rsaKeys[kid]; exists {
+			return key, nil
+		}
+		// Token tanpa kid masih diterima jika JWKS hanya berisi satu key
+		if kid == "" && len(v.rsaKeys) == 1 {
+			for _, key := range v.rsaKeys {
+				return key, nil
+			}
+		}
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	default:
+		return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+	}
+}
@@ -0,0 +1,62 @@
+package broker
+
+import (
+	"context"
+	"errors"
+
+	"livechat-ws/internal/domain"
+)
+
+// Jenis backend broker yang bisa dipilih lewat konfigurasi
+const (
+	TypeKafka  = "kafka"
+	TypeRedis  = "redis"
+	TypeMemory = "memory"
+)
+
+const (
+	TopicChatMessages     = "chat-messages"
+	TopicTypingIndicators = "typing-indicators"
+	TopicConnectionStatus = "connection-status"
+)
+
+// DefaultTopics adalah semua topic yang di-subscribe oleh WebSocket server
+var DefaultTopics = []string{TopicChatMessages, TopicTypingIndicators, TopicConnectionStatus}
+
+// HeaderOriginInstance berisi ID instance yang mem-publish event, dipakai subscriber
+// untuk tidak mem-broadcast ulang event yang sudah dikirim langsung oleh instance itu sendiri
+const HeaderOriginInstance = "origin-instance"
+
+// ErrMalformedEvent menandakan event tidak bisa di-decode atau versinya tidak didukung.
+// Mengulang event seperti ini tidak ada gunanya, berbeda dengan error dari handler.
+var ErrMalformedEvent = errors.New("malformed event")
+
+// Handler menerima event dari broker. Error yang dikembalikan berarti event belum
+// berhasil diproses dan boleh dikirim ulang oleh broker yang mendukungnya.
+type Handler interface {
+	HandleEvent(ctx context.Context, event *domain.EventEnvelope) error
+}
+
+// HealthChecker diimplementasikan broker yang bisa diperiksa untuk readiness
+type HealthChecker interface {
+	// Ping memastikan backend broker bisa dijangkau
+	Ping(ctx context.Context) error
+	// SubscriberHealth mengembalikan error jika loop subscriber berhenti atau terus gagal
+	SubscriberHealth() error
+}
+
+// HandlerFunc mengubah fungsi biasa menjadi Handler
+type HandlerFunc func(ctx context.Context, event *domain.EventEnvelope) error
+
+func (f HandlerFunc) HandleEvent(ctx context.Context, event *domain.EventEnvelope) error {
+	return f(ctx, event)
+}
+
+// Broker adalah transport event antara WebSocket server, instance lain, dan service lain
+type Broker interface {
+	// Publish mengirim event ke topic sesuai tipenya
+	Publish(ctx context.Context, event *domain.EventEnvelope) error
+	// Subscribe mulai menerima event dari topics di background sampai ctx selesai atau Close dipanggil
+	Subscribe(ctx context.Context, topics []string, handler Handler) error
+	Close() error
+}
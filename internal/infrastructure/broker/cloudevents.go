@@ -0,0 +1,204 @@
+package broker
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"livechat-ws/internal/domain"
+)
+
+// Mode CloudEvents: structured menaruh atribut dan data dalam satu JSON, binary menaruh
+// atribut di header transport (hanya Kafka) dan data apa adanya di value
+const (
+	CloudEventsStructured = "structured"
+	CloudEventsBinary     = "binary"
+)
+
+const (
+	CloudEventsSpecVersion = "1.0"
+	// CloudEventsContentType adalah content type record CloudEvents mode structured
+	CloudEventsContentType = "application/cloudevents+json"
+	// CloudEventsTypePrefix ditambahkan ke tipe event, misalnya livechat.new_message
+	CloudEventsTypePrefix = "livechat."
+
+	cloudEventsDataContentType = "application/json"
+	// cloudEventsVersionExtension menyimpan versi payload envelope
+	cloudEventsVersionExtension = "eventversion"
+)
+
+// Atribut CloudEvents yang bukan extension
+var cloudEventsContextAttributes = map[string]bool{
+	"specversion":     true,
+	"id":              true,
+	"source":          true,
+	"type":            true,
+	"time":            true,
+	"subject":         true,
+	"datacontenttype": true,
+	"dataschema":      true,
+}
+
+// CloudEventAttributes mengubah envelope menjadi atribut CloudEvents. subject adalah
+// session ID, metadata dikirim sebagai extension (nama extension tanpa underscore).
+func CloudEventAttributes(event *domain.EventEnvelope, source string) map[string]string {
+	timestamp := event.Timestamp
+	if timestamp.IsZero() {
+		timestamp = time.Now()
+	}
+
+	attributes := map[string]string{
+		"specversion":               CloudEventsSpecVersion,
+		"id":                        event.ID,
+		"source":                    source,
+		"type":                      CloudEventsTypePrefix + event.Type,
+		"time":                      timestamp.UTC().Format(time.RFC3339Nano),
+		"datacontenttype":           cloudEventsDataContentType,
+		cloudEventsVersionExtension: strconv.Itoa(event.Version),
+	}
+	if event.SessionID != "" {
+		attributes["subject"] = event.SessionID
+	}
+	for key, value := range event.Metadata {
+		attributes[cloudEventsExtensionName(key)] = value
+	}
+	return attributes
+}
+
+// EncodeCloudEvent menghasilkan CloudEvent mode structured
+func EncodeCloudEvent(event *domain.EventEnvelope, source string) ([]byte, error) {
+	structured := make(map[string]interface{})
+	for key, value := range CloudEventAttributes(event, source) {
+		structured[key] = value
+	}
+	structured["data"] = event.Payload
+	return json.Marshal(structured)
+}
+
+// DecodeCloudEvent mengubah atribut dan data CloudEvents kembali menjadi envelope
+func DecodeCloudEvent(attributes map[string]string, data []byte) (*domain.EventEnvelope, error) {
+	if attributes["specversion"] != CloudEventsSpecVersion {
+		return nil, fmt.Errorf("%w: unsupported CloudEvents specversion %q", ErrMalformedEvent, attributes["specversion"])
+	}
+	for _, required := range []string{"id", "source", "type"} {
+		if attributes[required] == "" {
+			return nil, fmt.Errorf("%w: CloudEvent without %s", ErrMalformedEvent, required)
+		}
+	}
+
+	event := &domain.EventEnvelope{
+		ID:        attributes["id"],
+		Type:      strings.TrimPrefix(attributes["type"], CloudEventsTypePrefix),
+		Version:   EventVersion,
+		SessionID: attributes["subject"],
+		Payload:   data,
+	}
+	if len(event.Payload) == 0 {
+		event.Payload = json.RawMessage("null")
+	}
+
+	if value := attributes["time"]; value != "" {
+		timestamp, err := time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid CloudEvent time: %v", ErrMalformedEvent, err)
+		}
+		event.Timestamp = timestamp
+	}
+	if value := attributes[cloudEventsVersionExtension]; value != "" {
+		version, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid CloudEvent %s: %v", ErrMalformedEvent, cloudEventsVersionExtension, err)
+		}
+		event.Version = version
+	}
+
+	for key, value := range attributes {
+		if cloudEventsContextAttributes[key] || key == cloudEventsVersionExtension {
+			continue
+		}
+		if event.Metadata == nil {
+			event.Metadata = make(map[string]string)
+		}
+		event.Metadata[metadataKeyForExtension(key)] = value
+	}
+
+	return event, nil
+}
+
+// decodeStructuredCloudEvent dipanggil Decode untuk value yang memiliki specversion
+func decodeStructuredCloudEvent(fields map[string]json.RawMessage) (*domain.EventEnvelope, error) {
+	attributes := make(map[string]string, len(fields))
+	for key, raw := range fields {
+		if key == "data" || key == "data_base64" {
+			continue
+		}
+		var value interface{}
+		if err := json.Unmarshal(raw, &value); err != nil {
+			return nil, fmt.Errorf("%w: invalid CloudEvent attribute %s: %v", ErrMalformedEvent, key, err)
+		}
+		switch v := value.(type) {
+		case string:
+			attributes[key] = v
+		case nil:
+		default:
+			attributes[key] = string(raw)
+		}
+	}
+	data, err := structuredCloudEventData(fields)
+	if err != nil {
+		return nil, err
+	}
+	return DecodeCloudEvent(attributes, data)
+}
+
+// structuredCloudEventData mengembalikan data CloudEvent sebagai JSON. data_base64
+// hanya diterima jika isinya JSON karena payload envelope selalu JSON.
+func structuredCloudEventData(fields map[string]json.RawMessage) (json.RawMessage, error) {
+	encoded, ok := fields["data_base64"]
+	if !ok {
+		return fields["data"], nil
+	}
+	if _, ok := fields["data"]; ok {
+		return nil, fmt.Errorf("%w: CloudEvent with both data and data_base64", ErrMalformedEvent)
+	}
+
+	var value string
+	if err := json.Unmarshal(encoded, &value); err != nil {
+		return nil, fmt.Errorf("%w: invalid CloudEvent data_base64: %v", ErrMalformedEvent, err)
+	}
+	data, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid CloudEvent data_base64: %v", ErrMalformedEvent, err)
+	}
+	if !json.Valid(data) {
+		return nil, fmt.Errorf("%w: CloudEvent data_base64 is not JSON", ErrMalformedEvent)
+	}
+	return data, nil
+}
+
+// SortedAttributeKeys mengembalikan nama atribut secara berurutan supaya header stabil
+func SortedAttributeKeys(attributes map[string]string) []string {
+	keys := make([]string, 0, len(attributes))
+	for key := range attributes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Nama extension CloudEvents hanya boleh huruf kecil dan angka
+func cloudEventsExtensionName(metadataKey string) string {
+	return strings.ToLower(strings.ReplaceAll(metadataKey, "_", ""))
+}
+
+// metadataKeyForExtension mengembalikan key metadata bawaan untuk extension yang dikenal
+func metadataKeyForExtension(extension string) string {
+	if extension == cloudEventsExtensionName(MetadataOriginInstance) {
+		return MetadataOriginInstance
+	}
+	return extension
+}
@@ -0,0 +1,130 @@
+package broker
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"livechat-ws/internal/domain"
+
+	"github.com/google/uuid"
+)
+
+// Format encoding event di broker
+const (
+	// FormatEnvelope mengirim domain.EventEnvelope apa adanya
+	FormatEnvelope = "envelope"
+	// FormatLegacy mengirim payload event bawaan tanpa envelope untuk service lama.
+	// Tipe event lain tetap dikirim sebagai envelope.
+	FormatLegacy = "legacy"
+	// FormatCloudEvents mengirim event sebagai CloudEvents 1.0
+	FormatCloudEvents = "cloudevents"
+)
+
+// legacyEventTypes memetakan topic ke tipe event untuk record tanpa envelope
+var legacyEventTypes = map[string]string{
+	TopicChatMessages:     EventNewMessage,
+	TopicTypingIndicators: EventTypingIndicator,
+	TopicConnectionStatus: EventConnectionStatus,
+}
+
+// Codec menentukan format event yang di-publish
+type Codec struct {
+	Format string
+	// CloudEventsMode adalah CloudEventsStructured atau CloudEventsBinary. Transport
+	// tanpa header (Redis, memory) selalu memakai structured.
+	CloudEventsMode string
+	// CloudEventsSource adalah atribut source CloudEvents
+	CloudEventsSource string
+}
+
+// Encode mengubah event ke bytes sesuai format. CloudEvents mode binary ditangani
+// transport karena atributnya dikirim sebagai header.
+func (c Codec) Encode(event *domain.EventEnvelope) ([]byte, error) {
+	switch c.Format {
+	case FormatCloudEvents:
+		return EncodeCloudEvent(event, c.CloudEventsSource)
+	case FormatLegacy:
+		if _, ok := eventTopics[event.Type]; ok {
+			return event.Payload, nil
+		}
+	}
+	return json.Marshal(event)
+}
+
+// IsCloudEventsBinary returns true if attributes should be sent as transport headers
+func (c Codec) IsCloudEventsBinary() bool {
+	return c.Format == FormatCloudEvents && c.CloudEventsMode == CloudEventsBinary
+}
+
+// Decode menerima CloudEvents mode structured, envelope, maupun record lama tanpa
+// envelope. Record lama dikenali dari topic-nya dan dibungkus menjadi envelope versi 1.
+// Record tanpa ID mendapat ID yang diturunkan dari topic dan isinya.
+func Decode(topic string, value []byte) (*domain.EventEnvelope, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(value, &fields); err != nil {
+		return nil, fmt.Errorf("%w: error unmarshaling event: %v", ErrMalformedEvent, err)
+	}
+
+	if _, ok := fields["specversion"]; ok {
+		return decodeStructuredCloudEvent(fields)
+	}
+
+	// Payload lama tidak pernah punya field payload, sedangkan envelope selalu punya
+	if _, ok := fields["payload"]; ok {
+		var event domain.EventEnvelope
+		if err := json.Unmarshal(value, &event); err != nil {
+			return nil, fmt.Errorf("%w: error unmarshaling event: %v", ErrMalformedEvent, err)
+		}
+		if event.Type == "" {
+			return nil, fmt.Errorf("%w: event without type", ErrMalformedEvent)
+		}
+		if event.Version == 0 {
+			event.Version = EventVersion
+		}
+		if event.ID == "" {
+			event.ID = contentEventID(topic, value)
+		}
+		return &event, nil
+	}
+
+	return decodeLegacy(topic, value)
+}
+
+func decodeLegacy(topic string, value []byte) (*domain.EventEnvelope, error) {
+	eventType, ok := legacyEventTypes[topic]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown topic: %s", ErrMalformedEvent, topic)
+	}
+
+	var legacy struct {
+		ID        string `json:"id"`
+		EventID   string `json:"event_id"`
+		SessionID string `json:"session_id"`
+	}
+	if err := json.Unmarshal(value, &legacy); err != nil {
+		return nil, fmt.Errorf("%w: error unmarshaling %s message: %v", ErrMalformedEvent, eventType, err)
+	}
+
+	id := legacy.EventID
+	if legacy.ID != "" && legacy.ID != uuid.Nil.String() {
+		id = legacy.ID
+	}
+	if id == "" {
+		id = contentEventID(topic, value)
+	}
+
+	return &domain.EventEnvelope{
+		ID:        id,
+		Type:      eventType,
+		Version:   EventVersion,
+		SessionID: legacy.SessionID,
+		Payload:   value,
+	}, nil
+}
+
+// contentEventID membuat event ID dari isi record untuk event yang tidak membawa ID.
+// Setiap instance yang membaca record yang sama mendapat ID yang sama, sehingga event
+// tetap mendapat satu seq di event log session.
+func contentEventID(topic string, value []byte) string {
+	return uuid.NewSHA1(uuid.NameSpaceOID, append([]byte(topic+"\x00"), value...)).String()
+}
@@ -0,0 +1,265 @@
+package broker
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"livechat-ws/internal/domain"
+)
+
+func TestDecode(t *testing.T) {
+	payload := `{"id":"6f1c3c2e-7a1b-4c55-9a57-0d1f5f0f8a11","session_id":"s1","message":"hello"}`
+	encodedPayload := base64.StdEncoding.EncodeToString([]byte(payload))
+
+	tests := []struct {
+		name        string
+		topic       string
+		value       string
+		wantErr     bool
+		wantID      string
+		wantType    string
+		wantSession string
+		wantPayload string
+		wantOrigin  string
+		wantVersion int
+	}{
+		{
+			name:   "legacy chat message",
+			topic:  TopicChatMessages,
+			value:  payload,
+			wantID: "6f1c3c2e-7a1b-4c55-9a57-0d1f5f0f8a11", wantType: EventNewMessage, wantSession: "s1",
+			wantPayload: payload, wantVersion: EventVersion,
+		},
+		{
+			name:   "legacy typing indicator with event_id",
+			topic:  TopicTypingIndicators,
+			value:  `{"event_id":"e1","session_id":"s1","is_typing":true}`,
+			wantID: "e1", wantType: EventTypingIndicator, wantSession: "s1",
+			wantPayload: `{"event_id":"e1","session_id":"s1","is_typing":true}`, wantVersion: EventVersion,
+		},
+		{
+			name:    "legacy record on unknown topic",
+			topic:   "other-topic",
+			value:   `{"session_id":"s1"}`,
+			wantErr: true,
+		},
+		{
+			name:   "envelope",
+			topic:  TopicChatMessages,
+			value:  `{"id":"e1","type":"new_message","version":2,"session_id":"s1","payload":{"message":"hello"},"metadata":{"origin_instance":"ws-1"}}`,
+			wantID: "e1", wantType: EventNewMessage, wantSession: "s1",
+			wantPayload: `{"message":"hello"}`, wantOrigin: "ws-1", wantVersion: 2,
+		},
+		{
+			name:   "envelope without version",
+			topic:  TopicTypingIndicators,
+			value:  `{"id":"e1","type":"read_receipt","session_id":"s1","payload":{}}`,
+			wantID: "e1", wantType: "read_receipt", wantSession: "s1", wantPayload: `{}`, wantVersion: EventVersion,
+		},
+		{
+			name:    "envelope without type",
+			topic:   TopicChatMessages,
+			value:   `{"id":"e1","session_id":"s1","payload":{}}`,
+			wantErr: true,
+		},
+		{
+			name:  "structured CloudEvent",
+			topic: TopicChatMessages,
+			value: `{"specversion":"1.0","id":"e1","source":"/chat-api","type":"livechat.new_message","subject":"s1",` +
+				`"time":"2025-01-24T10:30:00Z","eventversion":"3","origininstance":"ws-1","data":{"message":"hello"}}`,
+			wantID: "e1", wantType: EventNewMessage, wantSession: "s1",
+			wantPayload: `{"message":"hello"}`, wantOrigin: "ws-1", wantVersion: 3,
+		},
+		{
+			name:   "structured CloudEvent with data_base64",
+			topic:  TopicChatMessages,
+			value:  `{"specversion":"1.0","id":"e1","source":"/chat-api","type":"livechat.new_message","subject":"s1","data_base64":"` + encodedPayload + `"}`,
+			wantID: "e1", wantType: EventNewMessage, wantSession: "s1", wantPayload: payload, wantVersion: EventVersion,
+		},
+		{
+			name:    "structured CloudEvent with non-JSON data_base64",
+			topic:   TopicChatMessages,
+			value:   `{"specversion":"1.0","id":"e1","source":"/chat-api","type":"livechat.new_message","data_base64":"` + base64.StdEncoding.EncodeToString([]byte{0xff, 0x00}) + `"}`,
+			wantErr: true,
+		},
+		{
+			name:    "structured CloudEvent with invalid data_base64",
+			topic:   TopicChatMessages,
+			value:   `{"specversion":"1.0","id":"e1","source":"/chat-api","type":"livechat.new_message","data_base64":"not base64!"}`,
+			wantErr: true,
+		},
+		{
+			name:    "structured CloudEvent with data and data_base64",
+			topic:   TopicChatMessages,
+			value:   `{"specversion":"1.0","id":"e1","source":"/chat-api","type":"livechat.new_message","data":{},"data_base64":"` + encodedPayload + `"}`,
+			wantErr: true,
+		},
+		{
+			name:    "structured CloudEvent with unsupported specversion",
+			topic:   TopicChatMessages,
+			value:   `{"specversion":"0.3","id":"e1","source":"/chat-api","type":"livechat.new_message","data":{}}`,
+			wantErr: true,
+		},
+		{
+			name:    "structured CloudEvent without source",
+			topic:   TopicChatMessages,
+			value:   `{"specversion":"1.0","id":"e1","type":"livechat.new_message","data":{}}`,
+			wantErr: true,
+		},
+		{
+			name:    "structured CloudEvent with invalid time",
+			topic:   TopicChatMessages,
+			value:   `{"specversion":"1.0","id":"e1","source":"/chat-api","type":"livechat.new_message","time":"yesterday","data":{}}`,
+			wantErr: true,
+		},
+		{name: "not JSON", topic: TopicChatMessages, value: `hello`, wantErr: true},
+		{name: "JSON array", topic: TopicChatMessages, value: `[1,2]`, wantErr: true},
+		{name: "empty", topic: TopicChatMessages, value: ``, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event, err := Decode(tt.topic, []byte(tt.value))
+			if tt.wantErr {
+				if !errors.Is(err, ErrMalformedEvent) {
+					t.Fatalf("got error %v, want ErrMalformedEvent", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			assertEvent(t, event, tt.wantID, tt.wantType, tt.wantSession, tt.wantPayload, tt.wantVersion)
+			if origin := event.Metadata[MetadataOriginInstance]; origin != tt.wantOrigin {
+				t.Errorf("got origin %q, want %q", origin, tt.wantOrigin)
+			}
+		})
+	}
+}
+
+// TestDecodeDerivesMissingEventID memastikan record tanpa ID mendapat ID yang sama di
+// setiap instance yang membacanya
+func TestDecodeDerivesMissingEventID(t *testing.T) {
+	tests := []struct {
+		name  string
+		topic string
+		value string
+	}{
+		{name: "legacy typing indicator", topic: TopicTypingIndicators, value: `{"session_id":"s1","user_id":"u1","is_typing":true}`},
+		{name: "legacy chat message with nil id", topic: TopicChatMessages, value: `{"id":"00000000-0000-0000-0000-000000000000","session_id":"s1"}`},
+		{name: "envelope", topic: TopicChatMessages, value: `{"type":"read_receipt","session_id":"s1","payload":{}}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			first, err := Decode(tt.topic, []byte(tt.value))
+			if err != nil {
+				t.Fatal(err)
+			}
+			second, err := Decode(tt.topic, []byte(tt.value))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if first.ID == "" || first.ID != second.ID {
+				t.Fatalf("got ids %q and %q, want the same non-empty id", first.ID, second.ID)
+			}
+
+			other, err := Decode(tt.topic, []byte(tt.value[:len(tt.value)-1]+`,"x":1}`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if other.ID == first.ID {
+				t.Fatalf("different records got the same id %q", first.ID)
+			}
+		})
+	}
+}
+
+// TestDecodeBinaryCloudEvent memakai atribut seperti yang dibaca dari header ce_* Kafka
+func TestDecodeBinaryCloudEvent(t *testing.T) {
+	event := &domain.EventEnvelope{
+		ID:        "e1",
+		Type:      EventTypingIndicator,
+		Version:   2,
+		SessionID: "s1",
+		Payload:   json.RawMessage(`{"is_typing":true}`),
+		Metadata:  map[string]string{MetadataOriginInstance: "ws-1"},
+		Timestamp: time.Date(2025, 1, 24, 10, 30, 0, 0, time.UTC),
+	}
+
+	decoded, err := DecodeCloudEvent(CloudEventAttributes(event, "/livechat-ws"), event.Payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEvent(t, decoded, "e1", EventTypingIndicator, "s1", `{"is_typing":true}`, 2)
+	if !decoded.Timestamp.Equal(event.Timestamp) || decoded.Metadata[MetadataOriginInstance] != "ws-1" {
+		t.Errorf("got timestamp %v metadata %v", decoded.Timestamp, decoded.Metadata)
+	}
+
+	// Data kosong menjadi payload null
+	decoded, err = DecodeCloudEvent(map[string]string{"specversion": "1.0", "id": "e2", "source": "/chat-api", "type": "livechat.typing_indicator"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(decoded.Payload) != "null" {
+		t.Errorf("got payload %s, want null", decoded.Payload)
+	}
+
+	if _, err := DecodeCloudEvent(map[string]string{"specversion": "1.0", "source": "/chat-api", "type": "livechat.typing_indicator"}, nil); !errors.Is(err, ErrMalformedEvent) {
+		t.Errorf("CloudEvent without id: got %v, want ErrMalformedEvent", err)
+	}
+	if _, err := DecodeCloudEvent(map[string]string{"specversion": "1.0", "id": "e3", "source": "/chat-api", "type": "livechat.typing_indicator", "eventversion": "two"}, nil); !errors.Is(err, ErrMalformedEvent) {
+		t.Errorf("CloudEvent with invalid eventversion: got %v, want ErrMalformedEvent", err)
+	}
+}
+
+func TestCodecRoundTrip(t *testing.T) {
+	event, err := NewEvent(EventNewMessage, "e1", "s1", map[string]string{"id": "e1", "session_id": "s1", "message": "hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	event = WithOrigin(event, "ws-1")
+	payload := string(event.Payload)
+
+	tests := []struct {
+		format     string
+		wantOrigin string
+	}{
+		// Origin record legacy dikirim lewat header transport, bukan di value
+		{format: FormatLegacy},
+		{format: FormatEnvelope, wantOrigin: "ws-1"},
+		{format: FormatCloudEvents, wantOrigin: "ws-1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			value, err := Codec{Format: tt.format, CloudEventsSource: "/livechat-ws"}.Encode(event)
+			if err != nil {
+				t.Fatal(err)
+			}
+			decoded, err := Decode(TopicChatMessages, value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertEvent(t, decoded, "e1", EventNewMessage, "s1", payload, EventVersion)
+			if origin := decoded.Metadata[MetadataOriginInstance]; origin != tt.wantOrigin {
+				t.Errorf("got origin %q, want %q", origin, tt.wantOrigin)
+			}
+		})
+	}
+}
+
+func assertEvent(t *testing.T, event *domain.EventEnvelope, id, eventType, sessionID, payload string, version int) {
+	t.Helper()
+
+	if event.ID != id || event.Type != eventType || event.SessionID != sessionID || event.Version != version {
+		t.Errorf("got id=%q type=%q session=%q version=%d, want id=%q type=%q session=%q version=%d",
+			event.ID, event.Type, event.SessionID, event.Version, id, eventType, sessionID, version)
+	}
+	if string(event.Payload) != payload {
+		t.Errorf("got payload %s, want %s", event.Payload, payload)
+	}
+}
@@ -0,0 +1,92 @@
+package broker
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"livechat-ws/internal/domain"
+)
+
+// Tipe event bawaan. Tipe lain (read receipt, session closed, dst.) bisa didaftarkan
+// di Registry tanpa mengubah broker.
+const (
+	EventNewMessage       = "new_message"
+	EventTypingIndicator  = "typing_indicator"
+	EventConnectionStatus = "connection_status"
+)
+
+// EventVersion adalah versi payload event bawaan yang dihasilkan dan dipahami server ini
+const EventVersion = 1
+
+// MetadataOriginInstance berisi ID instance yang mem-publish event
+const MetadataOriginInstance = "origin_instance"
+
+// eventTopics memetakan tipe event bawaan ke topic-nya, tipe lain memakai TopicChatMessages
+var eventTopics = map[string]string{
+	EventNewMessage:       TopicChatMessages,
+	EventTypingIndicator:  TopicTypingIndicators,
+	EventConnectionStatus: TopicConnectionStatus,
+}
+
+// locallyDeliveredEvents adalah tipe event yang sudah di-broadcast langsung oleh
+// instance asal sebelum di-publish ke broker
+var locallyDeliveredEvents = map[string]bool{
+	EventTypingIndicator:  true,
+	EventConnectionStatus: true,
+}
+
+// NewEvent membungkus payload ke dalam envelope versi terbaru
+func NewEvent(eventType, id, sessionID string, payload interface{}) (*domain.EventEnvelope, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode %s payload: %w", eventType, err)
+	}
+
+	return &domain.EventEnvelope{
+		ID:        id,
+		Type:      eventType,
+		Version:   EventVersion,
+		SessionID: sessionID,
+		Payload:   data,
+		Timestamp: time.Now(),
+	}, nil
+}
+
+// DecodePayload men-decode payload event bawaan. Versi yang lebih baru dari EventVersion
+// dan payload yang rusak dikembalikan sebagai ErrMalformedEvent.
+func DecodePayload(event *domain.EventEnvelope, v interface{}) error {
+	if event.Version > EventVersion {
+		return fmt.Errorf("%w: unsupported %s version %d", ErrMalformedEvent, event.Type, event.Version)
+	}
+	if err := json.Unmarshal(event.Payload, v); err != nil {
+		return fmt.Errorf("%w: error unmarshaling %s payload: %v", ErrMalformedEvent, event.Type, err)
+	}
+	return nil
+}
+
+// TopicForEvent returns the topic an event is published to
+func TopicForEvent(event *domain.EventEnvelope) string {
+	if topic, ok := eventTopics[event.Type]; ok {
+		return topic
+	}
+	return TopicChatMessages // fallback to default topic
+}
+
+// WithOrigin mengembalikan salinan event dengan metadata instance asal
+func WithOrigin(event *domain.EventEnvelope, instanceID string) *domain.EventEnvelope {
+	copied := *event
+	copied.Metadata = make(map[string]string, len(event.Metadata)+1)
+	for key, value := range event.Metadata {
+		copied.Metadata[key] = value
+	}
+	copied.Metadata[MetadataOriginInstance] = instanceID
+	return &copied
+}
+
+// IsOwnEcho returns true if the event was published by this instance and was already
+// broadcast to local clients before publishing
+func IsOwnEcho(event *domain.EventEnvelope, instanceID string) bool {
+	origin := event.Metadata[MetadataOriginInstance]
+	return locallyDeliveredEvents[event.Type] && origin != "" && origin == instanceID
+}
@@ -0,0 +1,143 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+
+	"livechat-ws/internal/domain"
+)
+
+var errMemoryBrokerClosed = errors.New("memory broker is closed")
+
+// memoryQueueSize adalah jumlah event yang boleh menunggu per subscription
+const memoryQueueSize = 1024
+
+type memoryEvent struct {
+	topic string
+	value []byte
+}
+
+type memorySubscription struct {
+	topics  map[string]bool
+	handler Handler
+	queue   chan memoryEvent
+}
+
+// MemoryBroker adalah broker in-process untuk development lokal dan unit test.
+// Event hanya terlihat oleh subscriber di proses yang sama.
+type MemoryBroker struct {
+	instanceID    string
+	subscriptions []*memorySubscription
+	mutex         sync.RWMutex
+	wg            sync.WaitGroup
+	closed        bool
+	logger        *slog.Logger
+}
+
+func NewMemoryBroker(instanceID string, logger *slog.Logger) *MemoryBroker {
+	return &MemoryBroker{instanceID: instanceID, logger: logger}
+}
+
+func (m *MemoryBroker) Publish(ctx context.Context, event *domain.EventEnvelope) error {
+	// Encode seperti broker lain supaya subscriber tidak berbagi data dengan publisher
+	value, err := Codec{Format: FormatEnvelope}.Encode(WithOrigin(event, m.instanceID))
+	if err != nil {
+		return err
+	}
+	topic := TopicForEvent(event)
+
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	if m.closed {
+		return errMemoryBrokerClosed
+	}
+
+	for _, sub := range m.subscriptions {
+		if !sub.topics[topic] {
+			continue
+		}
+		select {
+		case sub.queue <- memoryEvent{topic: topic, value: value}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+func (m *MemoryBroker) Subscribe(ctx context.Context, topics []string, handler Handler) error {
+	sub := &memorySubscription{
+		topics:  make(map[string]bool),
+		handler: handler,
+		queue:   make(chan memoryEvent, memoryQueueSize),
+	}
+	for _, topic := range topics {
+		sub.topics[topic] = true
+	}
+
+	m.mutex.Lock()
+	if m.closed {
+		m.mutex.Unlock()
+		return errMemoryBrokerClosed
+	}
+	m.subscriptions = append(m.subscriptions, sub)
+	m.mutex.Unlock()
+
+	m.wg.Add(1)
+	go m.run(ctx, sub)
+	return nil
+}
+
+func (m *MemoryBroker) run(ctx context.Context, sub *memorySubscription) {
+	defer m.wg.Done()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event, ok := <-sub.queue:
+			if !ok {
+				return
+			}
+			m.dispatch(ctx, sub.handler, event)
+		}
+	}
+}
+
+func (m *MemoryBroker) dispatch(ctx context.Context, handler Handler, raw memoryEvent) {
+	defer func() {
+		if r := recover(); r != nil {
+			m.logger.Error("Recovered from panic in memory broker", "topic", raw.topic, "panic", r)
+		}
+	}()
+
+	event, err := Decode(raw.topic, raw.value)
+	if err != nil {
+		m.logger.Error("Memory broker failed to decode event", "topic", raw.topic, "error", err)
+		return
+	}
+	if IsOwnEcho(event, m.instanceID) {
+		return
+	}
+
+	if err := handler.HandleEvent(ctx, event); err != nil {
+		m.logger.Error("Memory broker failed to handle event", "topic", raw.topic, "event_type", event.Type, "event_id", event.ID, "error", err)
+	}
+}
+
+func (m *MemoryBroker) Close() error {
+	m.mutex.Lock()
+	if !m.closed {
+		m.closed = true
+		for _, sub := range m.subscriptions {
+			close(sub.queue)
+		}
+	}
+	m.mutex.Unlock()
+
+	m.wg.Wait()
+	return nil
+}
@@ -0,0 +1,52 @@
+package broker
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+
+	"livechat-ws/internal/domain"
+)
+
+// Registry meneruskan event ke handler yang terdaftar untuk tipenya. Registry sendiri
+// adalah Handler sehingga bisa langsung dipakai di Broker.Subscribe.
+type Registry struct {
+	handlers map[string]Handler
+	unknown  Handler
+	mutex    sync.RWMutex
+	logger   *slog.Logger
+}
+
+func NewRegistry(logger *slog.Logger) *Registry {
+	return &Registry{handlers: make(map[string]Handler), logger: logger}
+}
+
+// Register mendaftarkan handler untuk satu tipe event, menggantikan handler sebelumnya
+func (r *Registry) Register(eventType string, handler Handler) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.handlers[eventType] = handler
+}
+
+// HandleUnknown mendaftarkan handler untuk tipe event yang tidak terdaftar.
+// Tanpa handler ini, event tersebut di-log lalu dibuang.
+func (r *Registry) HandleUnknown(handler Handler) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.unknown = handler
+}
+
+func (r *Registry) HandleEvent(ctx context.Context, event *domain.EventEnvelope) error {
+	r.mutex.RLock()
+	handler, ok := r.handlers[event.Type]
+	if !ok {
+		handler = r.unknown
+	}
+	r.mutex.RUnlock()
+
+	if handler == nil {
+		r.logger.Debug("Dropping event with unknown type", "event_type", event.Type, "event_id", event.ID, "session_id", event.SessionID)
+		return nil
+	}
+	return handler.HandleEvent(ctx, event)
+}
@@ -0,0 +1,181 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"livechat-ws/internal/domain"
+	"livechat-ws/internal/infrastructure/broker"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// KafkaBroker mengimplementasikan broker.Broker dengan KafkaProducer dan KafkaConsumer
+type KafkaBroker struct {
+	config      Config
+	instanceID  string
+	producer    *KafkaProducer
+	deadLetters *DeadLetterQueue
+	consumers   []*KafkaConsumer
+	mutex       sync.Mutex
+}
+
+// NewKafkaBroker membuat broker Kafka. Dead-letter topic dipakai jika
+// config.Topics.DeadLetter diisi.
+func NewKafkaBroker(config Config, instanceID string) *KafkaBroker {
+	b := &KafkaBroker{
+		config:     config,
+		instanceID: instanceID,
+		producer:   NewKafkaProducer(config, instanceID),
+	}
+	if config.Topics.DeadLetter != "" {
+		b.deadLetters = NewDeadLetterQueue(config)
+	}
+	return b
+}
+
+// DeadLetters mengembalikan dead-letter queue broker ini, nil jika tidak dikonfigurasi
+func (b *KafkaBroker) DeadLetters() *DeadLetterQueue {
+	return b.deadLetters
+}
+
+// ValidateTopics memastikan semua topic yang dikonfigurasi sudah ada di cluster.
+// Topic tidak dibuat otomatis supaya salah ketik nama atau prefix langsung ketahuan.
+func (b *KafkaBroker) ValidateTopics(ctx context.Context) error {
+	required := make([]string, 0, len(broker.DefaultTopics)+1)
+	for _, topic := range broker.DefaultTopics {
+		required = append(required, b.config.Topics.Name(topic))
+	}
+	if b.deadLetters != nil {
+		required = append(required, b.deadLetters.Topic())
+	}
+
+	existing, err := listTopics(ctx, b.config)
+	if err != nil {
+		return fmt.Errorf("failed to list Kafka topics: %w", err)
+	}
+
+	var missing []string
+	for _, topic := range required {
+		if !existing[topic] {
+			missing = append(missing, topic)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing Kafka topics: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// Ping memastikan minimal satu broker Kafka bisa dihubungi (termasuk TLS/SASL)
+func (b *KafkaBroker) Ping(ctx context.Context) error {
+	conn, err := dialAny(ctx, b.config)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// SubscriberHealth mengembalikan error jika belum subscribe atau salah satu fetch loop
+// consumer berhenti atau terus gagal
+func (b *KafkaBroker) SubscriberHealth() error {
+	b.mutex.Lock()
+	consumers := b.consumers
+	b.mutex.Unlock()
+
+	if len(consumers) == 0 {
+		return errors.New("not subscribed")
+	}
+	for _, consumer := range consumers {
+		if err := consumer.Health(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *KafkaBroker) Publish(ctx context.Context, event *domain.EventEnvelope) error {
+	return b.producer.SendMessage(ctx, event)
+}
+
+func (b *KafkaBroker) Subscribe(ctx context.Context, topics []string, handler broker.Handler) error {
+	consumer := NewKafkaConsumer(b.config, topics, handler, b.instanceID, b.deadLetters)
+
+	b.mutex.Lock()
+	b.consumers = append(b.consumers, consumer)
+	b.mutex.Unlock()
+
+	return consumer.Start(ctx)
+}
+
+// Close menutup consumer lebih dulu supaya tidak ada event baru, lalu dead-letter queue dan producer
+func (b *KafkaBroker) Close() error {
+	b.mutex.Lock()
+	consumers := b.consumers
+	b.consumers = nil
+	b.mutex.Unlock()
+
+	var errs []error
+	for _, consumer := range consumers {
+		if err := consumer.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if b.deadLetters != nil {
+		if err := b.deadLetters.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := b.producer.Close(); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// listTopics mengembalikan semua topic di cluster dari broker pertama yang bisa dihubungi
+func listTopics(ctx context.Context, config Config) (map[string]bool, error) {
+	partitions, err := readPartitions(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+
+	topics := make(map[string]bool)
+	for _, partition := range partitions {
+		topics[partition.Topic] = true
+	}
+	return topics, nil
+}
+
+// readPartitions membaca metadata partition dari broker pertama yang bisa dihubungi.
+// Tanpa topics, semua partition di cluster dikembalikan.
+func readPartitions(ctx context.Context, config Config, topics ...string) ([]kafka.Partition, error) {
+	conn, err := dialAny(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	return conn.ReadPartitions(topics...)
+}
+
+// dialAny membuka koneksi ke broker pertama yang bisa dihubungi
+func dialAny(ctx context.Context, config Config) (*kafka.Conn, error) {
+	dialer := config.dialer()
+
+	var lastErr error
+	for _, address := range config.Brokers {
+		conn, err := dialer.DialContext(ctx, "tcp", address)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		return conn, nil
+	}
+	if lastErr == nil {
+		lastErr = errors.New("no Kafka brokers configured")
+	}
+	return nil, lastErr
+}
@@ -0,0 +1,55 @@
+package kafka
+
+import (
+	"strings"
+
+	"livechat-ws/internal/domain"
+	"livechat-ws/internal/infrastructure/broker"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// Header CloudEvents Kafka protocol binding: atribut memakai prefix ce_, sedangkan
+// datacontenttype dikirim sebagai content-type
+const (
+	cloudEventsHeaderPrefix = "ce_"
+	headerContentType       = "content-type"
+)
+
+// cloudEventHeaders mengubah atribut CloudEvents menjadi header record mode binary
+func cloudEventHeaders(event *domain.EventEnvelope, source string) []kafka.Header {
+	attributes := broker.CloudEventAttributes(event, source)
+
+	headers := make([]kafka.Header, 0, len(attributes))
+	for _, key := range broker.SortedAttributeKeys(attributes) {
+		name := cloudEventsHeaderPrefix + key
+		if key == "datacontenttype" {
+			name = headerContentType
+		}
+		headers = append(headers, kafka.Header{Key: name, Value: []byte(attributes[key])})
+	}
+	return headers
+}
+
+func isBinaryCloudEvent(m kafka.Message) bool {
+	for _, header := range m.Headers {
+		if header.Key == cloudEventsHeaderPrefix+"specversion" {
+			return true
+		}
+	}
+	return false
+}
+
+// decodeBinaryCloudEvent membaca atribut dari header ce_* dan data dari value
+func decodeBinaryCloudEvent(m kafka.Message) (*domain.EventEnvelope, error) {
+	attributes := make(map[string]string)
+	for _, header := range m.Headers {
+		switch {
+		case strings.HasPrefix(header.Key, cloudEventsHeaderPrefix):
+			attributes[strings.TrimPrefix(header.Key, cloudEventsHeaderPrefix)] = string(header.Value)
+		case header.Key == headerContentType:
+			attributes["datacontenttype"] = string(header.Value)
+		}
+	}
+	return broker.DecodeCloudEvent(attributes, m.Value)
+}
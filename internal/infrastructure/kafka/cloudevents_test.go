@@ -0,0 +1,44 @@
+package kafka
+
+import (
+	"testing"
+
+	"livechat-ws/internal/infrastructure/broker"
+
+	"github.com/segmentio/kafka-go"
+)
+
+func TestBinaryCloudEventRoundTrip(t *testing.T) {
+	event, err := broker.NewEvent(broker.EventTypingIndicator, "e1", "s1", map[string]bool{"is_typing": true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	event = broker.WithOrigin(event, "ws-1")
+
+	m := kafka.Message{Value: event.Payload, Headers: cloudEventHeaders(event, "/livechat-ws")}
+	if !isBinaryCloudEvent(m) {
+		t.Fatal("record with ce_specversion not detected as binary CloudEvent")
+	}
+
+	decoded, err := decodeBinaryCloudEvent(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.ID != "e1" || decoded.Type != broker.EventTypingIndicator || decoded.SessionID != "s1" || string(decoded.Payload) != `{"is_typing":true}` {
+		t.Fatalf("got %+v", decoded)
+	}
+	if decoded.Metadata[broker.MetadataOriginInstance] != "ws-1" {
+		t.Fatalf("got metadata %v, want origin ws-1", decoded.Metadata)
+	}
+
+	// Header ce_* tanpa id ditolak
+	var headers []kafka.Header
+	for _, header := range m.Headers {
+		if header.Key != cloudEventsHeaderPrefix+"id" {
+			headers = append(headers, header)
+		}
+	}
+	if _, err := decodeBinaryCloudEvent(kafka.Message{Value: m.Value, Headers: headers}); err == nil {
+		t.Fatal("binary CloudEvent without id accepted")
+	}
+}
@@ -0,0 +1,169 @@
+package kafka
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"livechat-ws/internal/infrastructure/broker"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+)
+
+// Config berisi semua pengaturan koneksi, topic, producer dan consumer Kafka
+type Config struct {
+	Brokers     []string
+	DialTimeout time.Duration
+	// TLS dan SASL boleh nil untuk koneksi plaintext tanpa autentikasi
+	TLS  *tls.Config
+	SASL sasl.Mechanism
+	// Codec menentukan format record yang di-publish, consumer menerima semua format
+	Codec    broker.Codec
+	Topics   TopicConfig
+	Producer ProducerConfig
+	Consumer ConsumerConfig
+	// Logger dipakai producer, consumer, dan DLQ; nil berarti slog.Default()
+	Logger *slog.Logger
+}
+
+// TopicConfig memetakan topic logis di package broker ke nama topic Kafka. Prefix
+// ditambahkan ke semua topic, termasuk dead-letter topic.
+type TopicConfig struct {
+	Prefix           string
+	ChatMessages     string
+	TypingIndicators string
+	ConnectionStatus string
+	// DeadLetter menampung record yang gagal diproses, kosong untuk menonaktifkan DLQ
+	DeadLetter string
+}
+
+// ProducerConfig mengatur Writer yang dipakai untuk publish event
+type ProducerConfig struct {
+	RequiredAcks kafka.RequiredAcks
+	BatchSize    int
+	BatchTimeout time.Duration
+	Compression  kafka.Compression
+	WriteTimeout time.Duration
+	ReadTimeout  time.Duration
+}
+
+// ConsumerConfig mengatur cara KafkaConsumer membaca dan meng-commit offset
+type ConsumerConfig struct {
+	GroupID string
+	// StartOffset dipakai hanya jika group belum punya offset yang di-commit
+	StartOffset int64
+	// MaxRetries adalah jumlah percobaan ulang handler sebelum record dilewati
+	MaxRetries int
+	// RetryBackoff adalah jeda retry pertama, berlipat dua setiap percobaan
+	RetryBackoff time.Duration
+	// MaxWait adalah waktu tunggu maksimal fetch saat belum ada data baru
+	MaxWait time.Duration
+	// CommitInterval mengelompokkan commit offset yang dikirim ke broker
+	CommitInterval time.Duration
+}
+
+// Name mengembalikan nama topic Kafka untuk topic logis
+func (t TopicConfig) Name(topic string) string {
+	switch topic {
+	case broker.TopicChatMessages:
+		return t.Prefix + nameOrDefault(t.ChatMessages, topic)
+	case broker.TopicTypingIndicators:
+		return t.Prefix + nameOrDefault(t.TypingIndicators, topic)
+	case broker.TopicConnectionStatus:
+		return t.Prefix + nameOrDefault(t.ConnectionStatus, topic)
+	default:
+		return t.Prefix + topic
+	}
+}
+
+// DeadLetterName mengembalikan nama dead-letter topic, kosong jika DLQ nonaktif
+func (t TopicConfig) DeadLetterName() string {
+	if t.DeadLetter == "" {
+		return ""
+	}
+	return t.Prefix + t.DeadLetter
+}
+
+// logical adalah kebalikan Name, dipakai consumer sebelum meneruskan record ke broker.Dispatch
+func (t TopicConfig) logical(name string) string {
+	for _, topic := range broker.DefaultTopics {
+		if t.Name(topic) == name {
+			return topic
+		}
+	}
+	return name
+}
+
+func nameOrDefault(name, fallback string) string {
+	if name == "" {
+		return fallback
+	}
+	return name
+}
+
+func (c Config) logger() *slog.Logger {
+	if c.Logger == nil {
+		return slog.Default()
+	}
+	return c.Logger
+}
+
+// dialer dipakai reader consumer dan koneksi metadata/DLQ
+func (c Config) dialer() *kafka.Dialer {
+	return &kafka.Dialer{
+		Timeout:       c.DialTimeout,
+		DualStack:     true,
+		TLS:           c.TLS,
+		SASLMechanism: c.SASL,
+	}
+}
+
+// transport dipakai oleh semua Writer
+func (c Config) transport() *kafka.Transport {
+	return &kafka.Transport{
+		DialTimeout: c.DialTimeout,
+		TLS:         c.TLS,
+		SASL:        c.SASL,
+	}
+}
+
+// ParseStartOffset mengubah nilai konfigurasi "earliest" atau "latest" ke offset kafka-go
+func ParseStartOffset(value string) (int64, error) {
+	switch value {
+	case "earliest":
+		return kafka.FirstOffset, nil
+	case "latest":
+		return kafka.LastOffset, nil
+	default:
+		return 0, fmt.Errorf("invalid start offset %q (expected earliest or latest)", value)
+	}
+}
+
+// ParseRequiredAcks menerima none, one, all atau angka 0, 1, -1
+func ParseRequiredAcks(value string) (kafka.RequiredAcks, error) {
+	var acks kafka.RequiredAcks
+	if err := acks.UnmarshalText([]byte(value)); err != nil {
+		return 0, err
+	}
+	return acks, nil
+}
+
+// ParseCompression menerima none, gzip, snappy, lz4 atau zstd
+func ParseCompression(value string) (kafka.Compression, error) {
+	switch value {
+	case "", "none":
+		return 0, nil
+	case "gzip":
+		return kafka.Gzip, nil
+	case "snappy":
+		return kafka.Snappy, nil
+	case "lz4":
+		return kafka.Lz4, nil
+	case "zstd":
+		return kafka.Zstd, nil
+	default:
+		return 0, fmt.Errorf("invalid compression %q (expected none, gzip, snappy, lz4 or zstd)", value)
+	}
+}
@@ -2,144 +2,424 @@ package kafka
 
 import (
 	"context"
-	"encoding/json"
-	"log"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"livechat-ws/internal/domain"
+	"livechat-ws/internal/infrastructure/broker"
+	"livechat-ws/internal/infrastructure/logging"
+	"livechat-ws/internal/infrastructure/metrics"
+	"livechat-ws/internal/infrastructure/tracing"
 
 	"github.com/segmentio/kafka-go"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
-type MessageHandler interface {
-	HandleNewMessage(msg domain.ChatMessage)
-	HandleTypingIndicator(msg domain.TypingMessage)
-	HandleConnectionStatus(msg domain.ConnectionStatusMessage)
+// maxRetryBackoff membatasi jeda antar percobaan ulang handler
+const maxRetryBackoff = 5 * time.Second
+
+// fetchFailureGrace adalah lama fetch boleh gagal berturut-turut sebelum consumer
+// dianggap tidak sehat
+const fetchFailureGrace = 30 * time.Second
+
+// readerStatsInterval adalah jeda pengambilan statistik reader untuk readiness
+const readerStatsInterval = 5 * time.Second
+
+// readerState mencatat kondisi fetch loop satu reader untuk readiness
+type readerState struct {
+	running atomic.Bool
+	// failingSince adalah waktu (unix nano) fetch pertama yang gagal berturut-turut, 0 jika sehat
+	failingSince atomic.Int64
+}
+
+// observe memperbarui failingSince dari statistik reader sejak sampel sebelumnya.
+// Reader dengan consumer group mengulang join, dial, dan fetch sendiri tanpa
+// mengembalikan error ke FetchMessage, sehingga kegagalannya hanya terlihat di
+// Stats().Errors. Fetch atau rebalance yang berhasil berarti reader sehat lagi;
+// sampel tanpa aktivitas sama sekali tidak mengubah status.
+func (s *readerState) observe(stats kafka.ReaderStats, now time.Time) {
+	switch {
+	case stats.Fetches > 0 || stats.Messages > 0 || stats.Rebalances > 0:
+		s.failingSince.Store(0)
+	case stats.Errors > 0:
+		s.failingSince.CompareAndSwap(0, now.UnixNano())
+	}
+}
+
+// offsetCommitter adalah bagian kafka.Reader yang dipakai worker partition, test
+// menggantinya supaya offset yang di-commit bisa diperiksa tanpa broker
+type offsetCommitter interface {
+	CommitMessages(ctx context.Context, msgs ...kafka.Message) error
 }
 
 type KafkaConsumer struct {
-	readers []*kafka.Reader
-	handler MessageHandler
+	readers     []*kafka.Reader
+	states      []*readerState
+	handler     broker.Handler
+	instanceID  string
+	config      ConsumerConfig
+	topics      TopicConfig
+	deadLetters *DeadLetterQueue
+	logger      *slog.Logger
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
 }
 
-func NewKafkaConsumer(brokers []string, groupID string, topics []string, handler MessageHandler) *KafkaConsumer {
+// NewKafkaConsumer membuat consumer untuk topic logis topics. deadLetters boleh nil:
+// record yang gagal di-handle kemudian diulang tanpa di-commit sampai berhasil, dan
+// record yang tidak bisa di-decode di-log lalu dilewati.
+func NewKafkaConsumer(config Config, topics []string, handler broker.Handler, instanceID string, deadLetters *DeadLetterQueue) *KafkaConsumer {
 	var readers []*kafka.Reader
+	var states []*readerState
 
 	for _, topic := range topics {
 		reader := kafka.NewReader(kafka.ReaderConfig{
-			Brokers:        brokers,
-			Topic:          topic,
-			GroupID:        groupID,
-			MinBytes:       1,                      // Read immediately, don't wait for batches
-			MaxBytes:       10e6,                   // 10MB max
-			CommitInterval: 100 * time.Millisecond, // Commit every 100ms instead of 1s
-			StartOffset:    kafka.LastOffset,
-			MaxWait:        100 * time.Millisecond, // Max wait 100ms for new data
+			Brokers:  config.Brokers,
+			Topic:    config.Topics.Name(topic),
+			GroupID:  config.Consumer.GroupID,
+			Dialer:   config.dialer(),
+			MinBytes: 1,    // Read immediately, don't wait for batches
+			MaxBytes: 10e6, // 10MB max
+			// Offset hanya di-commit lewat CommitMessages setelah handler berhasil,
+			// interval ini hanya mengelompokkan commit yang dikirim ke broker
+			CommitInterval: config.Consumer.CommitInterval,
+			StartOffset:    config.Consumer.StartOffset,
+			MaxWait:        config.Consumer.MaxWait,
 		})
 		readers = append(readers, reader)
+		states = append(states, &readerState{})
 	}
 
 	return &KafkaConsumer{
-		readers: readers,
-		handler: handler,
+		readers:     readers,
+		states:      states,
+		handler:     handler,
+		instanceID:  instanceID,
+		config:      config.Consumer,
+		topics:      config.Topics,
+		deadLetters: deadLetters,
+		logger:      config.logger(),
 	}
 }
 
 func (k *KafkaConsumer) Start(ctx context.Context) error {
+	ctx, k.cancel = context.WithCancel(ctx)
+
 	// Start consumers for each topic in separate goroutines
 	for i := range k.readers {
-		go func(readerIndex int) {
-			// Recovery dari panic untuk mencegah crash goroutine
-			defer func() {
-				if r := recover(); r != nil {
-					log.Printf("Recovered from panic in Kafka consumer goroutine %d: %v", readerIndex, r)
-				}
-			}()
-
-			reader := k.readers[readerIndex]
-			defer reader.Close()
-
-			for {
-				select {
-				case <-ctx.Done():
-					log.Printf("Kafka consumer for topic stopping...")
-					return
-				default:
-					m, err := reader.ReadMessage(ctx)
-					if err != nil {
-						// Handle specific Kafka errors more gracefully
-						if err.Error() == "[27] Rebalance In Progress: the coordinator has begun rebalancing the group, the client should rejoin the group" {
-							log.Printf("Kafka rebalance in progress, continuing...")
-							continue
-						}
-						if err.Error() == "[5] Leader Not Available: the cluster is in the middle of a leadership election and there is currently no leader for this partition and hence it is unavailable for writes" {
-							log.Printf("Kafka leader election in progress, continuing...")
-							continue
-						}
-						log.Printf("Error reading Kafka message: %v", err)
-						continue
-					}
-
-					if k.handler != nil {
-						k.handleMessage(m.Topic, m.Value)
-					}
-				}
-			}
-		}(i)
+		k.wg.Add(1)
+		k.states[i].running.Store(true)
+		go k.consume(ctx, k.readers[i], k.states[i])
 	}
 
 	return nil
 }
 
-func (k *KafkaConsumer) handleMessage(topic string, value []byte) {
-	// Recovery dari panic untuk mencegah crash consumer
+func (k *KafkaConsumer) consume(ctx context.Context, reader *kafka.Reader, state *readerState) {
+	defer k.wg.Done()
+	defer state.running.Store(false)
+	defer func() {
+		if err := reader.Close(); err != nil {
+			k.logger.Warn("Error closing Kafka reader", "topic", reader.Config().Topic, "error", err)
+		}
+	}()
+	// Recovery dari panic untuk mencegah crash goroutine
 	defer func() {
 		if r := recover(); r != nil {
-			log.Printf("Recovered from panic in handleMessage for topic %s: %v", topic, r)
+			k.logger.Error("Recovered from panic in Kafka consumer", "topic", reader.Config().Topic, "panic", r)
 		}
 	}()
 
-	log.Printf("Received Kafka message from topic %s", topic)
+	k.wg.Add(1)
+	go k.watchStats(ctx, reader, state)
 
-	switch topic {
-	case "chat-messages":
-		log.Printf("Processing chat message from Kafka: %s", string(value))
-		var chatMsg domain.ChatMessage
-		if err := json.Unmarshal(value, &chatMsg); err != nil {
-			log.Printf("Error unmarshaling chat message: %v", err)
-			log.Printf("Raw message: %s", string(value))
-			return
+	// Worker partition meng-commit record-nya sendiri, jadi reader baru ditutup
+	// setelah semua worker selesai
+	dispatcher := newPartitionDispatcher(func(m kafka.Message) {
+		k.processMessage(ctx, reader, m)
+	}, k.logger)
+	defer dispatcher.stop()
+
+	for {
+		m, err := reader.FetchMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, io.EOF) {
+				k.logger.Info("Kafka consumer stopping", "topic", reader.Config().Topic)
+				return
+			}
+			// Handle specific Kafka errors more gracefully
+			if err.Error() == "[27] Rebalance In Progress: the coordinator has begun rebalancing the group, the client should rejoin the group" {
+				k.logger.Info("Kafka rebalance in progress, continuing", "topic", reader.Config().Topic)
+				continue
+			}
+			if err.Error() == "[5] Leader Not Available: the cluster is in the middle of a leadership election and there is currently no leader for this partition and hence it is unavailable for writes" {
+				k.logger.Info("Kafka leader election in progress, continuing", "topic", reader.Config().Topic)
+				continue
+			}
+			state.failingSince.CompareAndSwap(0, time.Now().UnixNano())
+			metrics.KafkaConsumeErrors.WithLabelValues(reader.Config().Topic).Inc()
+			k.logger.Error("Error reading Kafka message", "topic", reader.Config().Topic, "error", err)
+			continue
 		}
-		log.Printf("Successfully unmarshaled chat message: ID=%s, SessionID=%s, SenderType=%s",
-			chatMsg.ID, chatMsg.SessionID, chatMsg.SenderType)
-		k.handler.HandleNewMessage(chatMsg)
 
-	case "typing-indicators":
-		var typingMsg domain.TypingMessage
-		if err := json.Unmarshal(value, &typingMsg); err != nil {
-			log.Printf("Error unmarshaling typing message: %v", err)
+		state.failingSince.Store(0)
+		metrics.KafkaConsumed.WithLabelValues(m.Topic).Inc()
+		metrics.ObserveKafkaLag(m.Topic, m.Partition, m.Offset, m.HighWaterMark)
+		dispatcher.dispatch(m)
+	}
+}
+
+// watchStats mengambil statistik reader secara berkala untuk Health. Stats() mereset
+// counter reader, jadi hanya goroutine ini yang boleh memanggilnya.
+func (k *KafkaConsumer) watchStats(ctx context.Context, reader *kafka.Reader, state *readerState) {
+	defer k.wg.Done()
+
+	ticker := time.NewTicker(readerStatsInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
 			return
+		case now := <-ticker.C:
+			stats := reader.Stats()
+			if stats.Errors > 0 {
+				k.logger.Warn("Kafka reader errors", "topic", stats.Topic, "errors", stats.Errors,
+					"fetches", stats.Fetches, "dials", stats.Dials)
+			}
+			state.observe(stats, now)
 		}
-		k.handler.HandleTypingIndicator(typingMsg)
+	}
+}
 
-	case "connection-status":
-		var statusMsg domain.ConnectionStatusMessage
-		if err := json.Unmarshal(value, &statusMsg); err != nil {
-			log.Printf("Error unmarshaling connection status message: %v", err)
-			return
+// processMessage dipanggil oleh worker partition secara berurutan per partition.
+// Offset di-commit hanya setelah handler berhasil (at-least-once), atau setelah
+// record yang tidak bisa diproses tersimpan di dead-letter topic.
+func (k *KafkaConsumer) processMessage(ctx context.Context, committer offsetCommitter, m kafka.Message) {
+	// Setelah shutdown dimulai record tidak diproses dan tidak di-commit,
+	// sehingga dibaca ulang oleh consumer berikutnya
+	if ctx.Err() != nil {
+		return
+	}
+
+	topic := k.topics.logical(m.Topic)
+
+	// Span consume melanjutkan trace dari producer lewat header traceparent
+	ctx, span := tracing.Tracer().Start(tracing.Extract(ctx, headerCarrier{&m.Headers}), "kafka.consume "+m.Topic,
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			semconv.MessagingSystemKafka,
+			semconv.MessagingDestinationName(m.Topic),
+			semconv.MessagingKafkaDestinationPartition(m.Partition),
+			semconv.MessagingKafkaMessageOffset(int(m.Offset)),
+		))
+	defer span.End()
+
+	if k.handler != nil {
+		err := k.handleWithRetry(ctx, topic, m)
+
+		// Tanpa DLQ record yang gagal di-handle tidak boleh hilang: offset tidak di-commit dan
+		// handler terus diulang sampai berhasil atau shutdown, walaupun partition ini tertahan.
+		// Record yang tidak bisa di-decode tidak akan pernah berhasil sehingga tetap dilewati.
+		backoff := k.config.RetryBackoff
+		for err != nil && k.deadLetters == nil && !errors.Is(err, broker.ErrMalformedEvent) && ctx.Err() == nil {
+			metrics.KafkaConsumeErrors.WithLabelValues(m.Topic).Inc()
+			k.logger.Error("Kafka record failed and no dead-letter topic is configured, retrying without commit",
+				recordAttrs(m), "backoff", backoff, "error", err)
+
+			select {
+			case <-ctx.Done():
+			case <-time.After(backoff):
+				err = k.handleWithRetry(ctx, topic, m)
+			}
+
+			backoff *= 2
+			if backoff > maxRetryBackoff {
+				backoff = maxRetryBackoff
+			}
+		}
+
+		if err != nil {
+			tracing.RecordError(span, err)
+			if ctx.Err() != nil {
+				return
+			}
+			metrics.KafkaConsumeErrors.WithLabelValues(m.Topic).Inc()
+			if !k.deadLetter(ctx, m, err) {
+				return
+			}
+		}
+	}
+
+	// Context terpisah supaya record yang sudah diproses tetap di-commit saat shutdown
+	if err := committer.CommitMessages(context.Background(), m); err != nil {
+		k.logger.Error("Failed to commit Kafka offset", recordAttrs(m), "error", err)
+	}
+}
+
+// handleWithRetry mengulang handler dengan exponential backoff. Record yang tidak bisa
+// di-decode tidak diulang.
+func (k *KafkaConsumer) handleWithRetry(ctx context.Context, topic string, m kafka.Message) error {
+	event, err := k.decode(topic, m)
+	if err != nil {
+		return err
+	}
+	k.logger.Debug("Received Kafka message", recordAttrs(m), "session_id", event.SessionID,
+		"event_type", event.Type, "event_id", event.ID)
+	trace.SpanFromContext(ctx).SetAttributes(
+		tracing.AttrSessionID.String(event.SessionID),
+		tracing.AttrEventID.String(event.ID),
+		tracing.AttrEventType.String(event.Type),
+	)
+	if broker.IsOwnEcho(event, k.instanceID) {
+		return nil
+	}
+
+	backoff := k.config.RetryBackoff
+
+	for attempt := 0; ; attempt++ {
+		err := k.handleEvent(ctx, event)
+		if err == nil || errors.Is(err, broker.ErrMalformedEvent) || attempt >= k.config.MaxRetries {
+			return err
+		}
+
+		k.logger.Warn("Error handling Kafka record, retrying", recordAttrs(m), "session_id", event.SessionID,
+			"attempt", attempt+1, "max_attempts", k.config.MaxRetries+1, "backoff", backoff, "error", err)
+		trace.SpanFromContext(ctx).AddEvent("retry", trace.WithAttributes(
+			attribute.Int("attempt", attempt+1),
+			attribute.String("error", err.Error()),
+		))
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if backoff > maxRetryBackoff {
+			backoff = maxRetryBackoff
+		}
+	}
+}
+
+// deadLetter memindahkan record yang gagal ke dead-letter topic. Penulisan diulang
+// sampai berhasil; false berarti shutdown dimulai dan record tidak boleh di-commit.
+// Tanpa DLQ hanya record yang tidak bisa di-decode yang sampai ke sini, dan dilewati.
+func (k *KafkaConsumer) deadLetter(ctx context.Context, m kafka.Message, cause error) bool {
+	if k.deadLetters == nil {
+		k.logger.Error("Giving up on malformed Kafka record", recordAttrs(m), "error", cause)
+		k.logger.Debug("Raw Kafka record", recordAttrs(m), logging.KeyPayload, string(m.Value))
+		return true
+	}
+
+	backoff := k.config.RetryBackoff
+	for {
+		err := k.deadLetters.Send(ctx, m, cause)
+		if err == nil {
+			k.logger.Error("Moved Kafka record to dead-letter topic", recordAttrs(m),
+				"dead_letter_topic", k.deadLetters.Topic(), "error", cause)
+			return true
+		}
+
+		k.logger.Error("Failed to write Kafka record to dead-letter topic, retrying", recordAttrs(m),
+			"backoff", backoff, "error", err)
+
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if backoff > maxRetryBackoff {
+			backoff = maxRetryBackoff
+		}
+	}
+}
+
+// decode mengubah record menjadi envelope. CloudEvents mode binary dikenali dari header
+// ce_specversion, format lain dari isi value. Record format lama membawa instance asal
+// di header, bukan di metadata.
+func (k *KafkaConsumer) decode(topic string, m kafka.Message) (*domain.EventEnvelope, error) {
+	var event *domain.EventEnvelope
+	var err error
+	if isBinaryCloudEvent(m) {
+		event, err = decodeBinaryCloudEvent(m)
+	} else {
+		event, err = broker.Decode(topic, m.Value)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if event.Metadata[broker.MetadataOriginInstance] == "" {
+		for _, header := range m.Headers {
+			if header.Key == broker.HeaderOriginInstance {
+				event = broker.WithOrigin(event, string(header.Value))
+				break
+			}
 		}
-		k.handler.HandleConnectionStatus(statusMsg)
+	}
+	return event, nil
+}
 
-	default:
-		log.Printf("Unknown topic: %s", topic)
+func (k *KafkaConsumer) handleEvent(ctx context.Context, event *domain.EventEnvelope) (err error) {
+	// Recovery dari panic untuk mencegah crash consumer, panic diperlakukan sebagai error
+	defer func() {
+		if r := recover(); r != nil {
+			k.logger.Error("Recovered from panic in event handler", "event_type", event.Type, "event_id", event.ID, "panic", r)
+			err = fmt.Errorf("panic in handler: %v", r)
+		}
+	}()
+
+	return k.handler.HandleEvent(ctx, event)
+}
+
+// Health mengembalikan error jika salah satu fetch loop berhenti (misalnya karena panic)
+// atau reader gagal terus (dari error FetchMessage maupun statistik reader) lebih lama
+// dari fetchFailureGrace
+func (k *KafkaConsumer) Health() error {
+	for i, state := range k.states {
+		topic := k.readers[i].Config().Topic
+		if !state.running.Load() {
+			return fmt.Errorf("consumer for topic %s is not running", topic)
+		}
+		if since := state.failingSince.Load(); since != 0 {
+			failingSince := time.Unix(0, since)
+			if time.Since(failingSince) > fetchFailureGrace {
+				return fmt.Errorf("consumer for topic %s failing since %s", topic, failingSince.Format(time.RFC3339))
+			}
+		}
 	}
+	return nil
 }
 
+// Close menghentikan fetch loop, menunggu worker selesai meng-commit, lalu menutup reader
 func (k *KafkaConsumer) Close() error {
+	if k.cancel != nil {
+		k.cancel()
+		k.wg.Wait()
+		return nil
+	}
+
 	for i := range k.readers {
 		if err := k.readers[i].Close(); err != nil {
-			log.Printf("Error closing Kafka reader: %v", err)
+			k.logger.Warn("Error closing Kafka reader", "topic", k.readers[i].Config().Topic, "error", err)
 		}
 	}
 	return nil
 }
+
+// recordAttrs mengelompokkan posisi record Kafka untuk log
+func recordAttrs(m kafka.Message) slog.Attr {
+	return slog.Group("record", "topic", m.Topic, "partition", m.Partition, "offset", m.Offset)
+}
@@ -0,0 +1,389 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"livechat-ws/internal/domain"
+	"livechat-ws/internal/infrastructure/broker"
+
+	"github.com/segmentio/kafka-go"
+)
+
+func newTestLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+func TestReaderStateObserve(t *testing.T) {
+	now := time.Now()
+	earlier := now.Add(-time.Minute)
+
+	tests := []struct {
+		name  string
+		since time.Time
+		stats kafka.ReaderStats
+		want  time.Time
+	}{
+		{name: "errors start failing", stats: kafka.ReaderStats{Errors: 1}, want: now},
+		{name: "errors keep first failure", since: earlier, stats: kafka.ReaderStats{Errors: 3, Dials: 3}, want: earlier},
+		{name: "idle sample keeps failure", since: earlier, stats: kafka.ReaderStats{}, want: earlier},
+		{name: "fetch recovers", since: earlier, stats: kafka.ReaderStats{Fetches: 1, Errors: 1}, want: time.Time{}},
+		{name: "rebalance recovers", since: earlier, stats: kafka.ReaderStats{Rebalances: 1}, want: time.Time{}},
+		{name: "healthy idle reader", stats: kafka.ReaderStats{Fetches: 2}, want: time.Time{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state := &readerState{}
+			if !tt.since.IsZero() {
+				state.failingSince.Store(tt.since.UnixNano())
+			}
+			state.observe(tt.stats, now)
+
+			var want int64
+			if !tt.want.IsZero() {
+				want = tt.want.UnixNano()
+			}
+			if got := state.failingSince.Load(); got != want {
+				t.Fatalf("got failingSince %d, want %d", got, want)
+			}
+		})
+	}
+}
+
+// TestHealthFailsWhenGroupReaderCannotReachBroker memakai reader consumer group sungguhan.
+// FetchMessage tidak pernah mengembalikan error join group, jadi kegagalan hanya terlihat
+// dari statistik reader.
+func TestHealthFailsWhenGroupReaderCannotReachBroker(t *testing.T) {
+	config := Config{
+		Brokers:  []string{"127.0.0.1:1"},
+		Consumer: ConsumerConfig{GroupID: "livechat-ws-test"},
+		Logger:   newTestLogger(),
+	}
+	consumer := NewKafkaConsumer(config, []string{broker.TopicChatMessages}, nil, "ws-1", nil)
+	defer consumer.Close()
+
+	// Consumer tidak di-Start: Stats() mereset counter, jadi hanya test yang boleh memanggilnya
+	state := consumer.states[0]
+	state.running.Store(true)
+	if err := consumer.Health(); err != nil {
+		t.Fatalf("healthy before any failure: %v", err)
+	}
+
+	// Sampel diberi waktu lampau supaya masa tenggang sudah lewat
+	sampledAt := time.Now().Add(-fetchFailureGrace - time.Second)
+	deadline := time.Now().Add(10 * time.Second)
+	for state.failingSince.Load() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("reader stats never reported an error")
+		}
+		time.Sleep(50 * time.Millisecond)
+		state.observe(consumer.readers[0].Stats(), sampledAt)
+	}
+
+	err := consumer.Health()
+	if err == nil || !strings.Contains(err.Error(), "failing since") {
+		t.Fatalf("got health %v, want failing consumer", err)
+	}
+}
+
+// recordingCommitter menyimpan offset yang di-commit sebagai pengganti kafka.Reader
+type recordingCommitter struct {
+	mutex   sync.Mutex
+	commits []kafka.Message
+}
+
+func (c *recordingCommitter) CommitMessages(ctx context.Context, msgs ...kafka.Message) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.commits = append(c.commits, msgs...)
+	return nil
+}
+
+// committed mengembalikan "partition/offset" yang sudah di-commit secara berurutan
+func (c *recordingCommitter) committed() []string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	positions := make([]string, 0, len(c.commits))
+	for _, m := range c.commits {
+		positions = append(positions, fmt.Sprintf("%d/%d", m.Partition, m.Offset))
+	}
+	return positions
+}
+
+// testRecord membuat record envelope di topic chat messages dengan event ID "partition/offset"
+func testRecord(t *testing.T, partition int, offset int64) kafka.Message {
+	t.Helper()
+
+	event, err := broker.NewEvent(broker.EventNewMessage, fmt.Sprintf("%d/%d", partition, offset), "session-1", map[string]string{"message": "hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	value, err := broker.Codec{Format: broker.FormatEnvelope}.Encode(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return kafka.Message{Topic: broker.TopicChatMessages, Partition: partition, Offset: offset, Value: value}
+}
+
+func newTestConsumer(handler broker.Handler, deadLetters *DeadLetterQueue) *KafkaConsumer {
+	config := Config{
+		Brokers:  []string{"127.0.0.1:1"},
+		Consumer: ConsumerConfig{MaxRetries: 2, RetryBackoff: time.Millisecond},
+		Logger:   newTestLogger(),
+	}
+	return NewKafkaConsumer(config, nil, handler, "ws-1", deadLetters)
+}
+
+func TestOffsetCommittedOnlyAfterHandlerSucceeds(t *testing.T) {
+	committer := &recordingCommitter{}
+	attempts := 0
+	handler := broker.HandlerFunc(func(ctx context.Context, event *domain.EventEnvelope) error {
+		attempts++
+		if committed := committer.committed(); len(committed) != 0 {
+			t.Errorf("offset committed before handler finished: %v", committed)
+		}
+		if attempts < 3 {
+			return errors.New("redis unavailable")
+		}
+		return nil
+	})
+
+	consumer := newTestConsumer(handler, nil)
+	consumer.processMessage(context.Background(), committer, testRecord(t, 0, 7))
+
+	if attempts != 3 {
+		t.Fatalf("got %d handler attempts, want 3", attempts)
+	}
+	if committed := committer.committed(); len(committed) != 1 || committed[0] != "0/7" {
+		t.Fatalf("got commits %v, want [0/7]", committed)
+	}
+}
+
+func TestOffsetNotCommittedWhenShutdownInterruptsRetry(t *testing.T) {
+	committer := &recordingCommitter{}
+	ctx, cancel := context.WithCancel(context.Background())
+	handler := broker.HandlerFunc(func(ctx context.Context, event *domain.EventEnvelope) error {
+		// Shutdown dimulai saat handler masih gagal
+		cancel()
+		return errors.New("redis unavailable")
+	})
+
+	consumer := newTestConsumer(handler, nil)
+	consumer.processMessage(ctx, committer, testRecord(t, 0, 7))
+
+	if committed := committer.committed(); len(committed) != 0 {
+		t.Fatalf("got commits %v, want none so the record is redelivered", committed)
+	}
+}
+
+func TestPartitionOrderingWhileOtherPartitionsProceed(t *testing.T) {
+	committer := &recordingCommitter{}
+	release := make(chan struct{})
+
+	var mutex sync.Mutex
+	handled := map[int][]string{}
+	handler := broker.HandlerFunc(func(ctx context.Context, event *domain.EventEnvelope) error {
+		// Record pertama partition 0 tertahan, misalnya client lambat
+		if event.ID == "0/0" {
+			<-release
+		}
+		var partition int
+		fmt.Sscanf(event.ID, "%d/", &partition)
+
+		mutex.Lock()
+		defer mutex.Unlock()
+		handled[partition] = append(handled[partition], event.ID)
+		return nil
+	})
+
+	consumer := newTestConsumer(handler, nil)
+	dispatcher := newPartitionDispatcher(func(m kafka.Message) {
+		consumer.processMessage(context.Background(), committer, m)
+	}, newTestLogger())
+
+	for offset := int64(0); offset < 3; offset++ {
+		dispatcher.dispatch(testRecord(t, 0, offset))
+		dispatcher.dispatch(testRecord(t, 1, offset))
+	}
+
+	// Partition 1 selesai walaupun partition 0 masih tertahan
+	deadline := time.Now().Add(5 * time.Second)
+	for len(committer.committed()) < 3 {
+		if time.Now().After(deadline) {
+			t.Fatalf("partition 1 blocked by partition 0, commits %v", committer.committed())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if committed := strings.Join(committer.committed(), ","); committed != "1/0,1/1,1/2" {
+		t.Fatalf("got commits %s while partition 0 is blocked, want 1/0,1/1,1/2", committed)
+	}
+
+	close(release)
+	dispatcher.stop()
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	for partition := 0; partition < 2; partition++ {
+		want := fmt.Sprintf("%d/0,%d/1,%d/2", partition, partition, partition)
+		if got := strings.Join(handled[partition], ","); got != want {
+			t.Errorf("partition %d handled %s, want %s", partition, got, want)
+		}
+	}
+	if committed := strings.Join(committer.committed(), ","); committed != "1/0,1/1,1/2,0/0,0/1,0/2" {
+		t.Errorf("got commits %s, want partition 0 committed in order after release", committed)
+	}
+}
+
+func TestExhaustedRecordMovesToDeadLetterTopicThenCommits(t *testing.T) {
+	committer := &recordingCommitter{}
+	writer := &recordingWriter{}
+	deadLetters := &DeadLetterQueue{topic: "livechat-ws-dlq", writer: writer}
+
+	attempts := 0
+	handler := broker.HandlerFunc(func(ctx context.Context, event *domain.EventEnvelope) error {
+		attempts++
+		return errors.New("session lookup failed")
+	})
+	consumer := newTestConsumer(handler, deadLetters)
+
+	record := testRecord(t, 2, 41)
+	record.Key = []byte("session-1")
+	record.Headers = []kafka.Header{
+		{Key: broker.HeaderOriginInstance, Value: []byte("chat-api")},
+		{Key: "traceparent", Value: []byte("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")},
+		// Header DLQ lama dari record yang pernah di-reinject diganti, bukan diduplikasi
+		{Key: HeaderDLQError, Value: []byte("old error")},
+	}
+	before := time.Now().UTC()
+	consumer.processMessage(context.Background(), committer, record)
+
+	if attempts != 3 {
+		t.Fatalf("got %d handler attempts, want 3", attempts)
+	}
+	if len(writer.messages) != 1 {
+		t.Fatalf("got %d dead letters, want 1", len(writer.messages))
+	}
+	dead := writer.messages[0]
+	if dead.Topic != "livechat-ws-dlq" || string(dead.Key) != "session-1" || string(dead.Value) != string(record.Value) {
+		t.Fatalf("got dead letter topic=%s key=%s value=%s, want original record in livechat-ws-dlq", dead.Topic, dead.Key, dead.Value)
+	}
+
+	headers := map[string][]string{}
+	for _, header := range dead.Headers {
+		headers[header.Key] = append(headers[header.Key], string(header.Value))
+	}
+	want := map[string]string{
+		broker.HeaderOriginInstance: "chat-api",
+		"traceparent":               "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		HeaderDLQOriginalTopic:      broker.TopicChatMessages,
+		HeaderDLQOriginalPartition:  "2",
+		HeaderDLQOriginalOffset:     "41",
+		HeaderDLQError:              "session lookup failed",
+	}
+	for key, value := range want {
+		if got := headers[key]; len(got) != 1 || got[0] != value {
+			t.Errorf("header %s: got %v, want [%s]", key, got, value)
+		}
+	}
+	failedAt, err := time.Parse(time.RFC3339Nano, strings.Join(headers[HeaderDLQFailedAt], ""))
+	if err != nil || failedAt.Before(before) {
+		t.Errorf("header %s: got %v, want failure time after %s", HeaderDLQFailedAt, headers[HeaderDLQFailedAt], before)
+	}
+
+	if committed := committer.committed(); len(committed) != 1 || committed[0] != "2/41" {
+		t.Fatalf("got commits %v, want [2/41] after dead-lettering", committed)
+	}
+}
+
+func TestMalformedRecordMovesToDeadLetterTopicWithoutRetry(t *testing.T) {
+	committer := &recordingCommitter{}
+	writer := &recordingWriter{}
+	attempts := 0
+	handler := broker.HandlerFunc(func(ctx context.Context, event *domain.EventEnvelope) error {
+		attempts++
+		return nil
+	})
+	consumer := newTestConsumer(handler, &DeadLetterQueue{topic: "livechat-ws-dlq", writer: writer})
+
+	consumer.processMessage(context.Background(), committer, kafka.Message{Topic: broker.TopicChatMessages, Offset: 3, Value: []byte("not json")})
+
+	if attempts != 0 {
+		t.Fatalf("handler called %d times for malformed record", attempts)
+	}
+	if len(writer.messages) != 1 || len(committer.committed()) != 1 {
+		t.Fatalf("got %d dead letters and commits %v, want 1 each", len(writer.messages), committer.committed())
+	}
+}
+
+func TestOffsetNotCommittedWhenDeadLetterWriteFails(t *testing.T) {
+	committer := &recordingCommitter{}
+	ctx, cancel := context.WithCancel(context.Background())
+	writer := &recordingWriter{err: errors.New("dlq unavailable")}
+	handler := broker.HandlerFunc(func(ctx context.Context, event *domain.EventEnvelope) error {
+		return errors.New("session lookup failed")
+	})
+	consumer := newTestConsumer(handler, &DeadLetterQueue{topic: "livechat-ws-dlq", writer: writer})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		consumer.processMessage(ctx, committer, testRecord(t, 0, 7))
+	}()
+
+	// Penulisan DLQ diulang terus, record tidak di-commit sampai shutdown
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	<-done
+
+	if committed := committer.committed(); len(committed) != 0 {
+		t.Fatalf("got commits %v, want none while the dead-letter topic is unavailable", committed)
+	}
+}
+
+func TestOffsetNotCommittedWithoutDeadLetterTopic(t *testing.T) {
+	committer := &recordingCommitter{}
+	attempts := 0
+	handler := broker.HandlerFunc(func(ctx context.Context, event *domain.EventEnvelope) error {
+		attempts++
+		// Gagal melewati MaxRetries beberapa kali sebelum akhirnya berhasil
+		if attempts < 10 {
+			if committed := committer.committed(); len(committed) != 0 {
+				t.Errorf("offset committed after %d failed attempts: %v", attempts, committed)
+			}
+			return errors.New("session lookup failed")
+		}
+		return nil
+	})
+	consumer := newTestConsumer(handler, nil)
+	consumer.processMessage(context.Background(), committer, testRecord(t, 0, 7))
+
+	if attempts != 10 {
+		t.Fatalf("got %d handler attempts, want 10", attempts)
+	}
+	if committed := committer.committed(); len(committed) != 1 || committed[0] != "0/7" {
+		t.Fatalf("got commits %v, want [0/7] once the handler succeeds", committed)
+	}
+
+	// Shutdown saat handler masih gagal: record tidak di-commit dan dibaca ulang nanti
+	failing := broker.HandlerFunc(func(ctx context.Context, event *domain.EventEnvelope) error {
+		return errors.New("session lookup failed")
+	})
+	consumer = newTestConsumer(failing, nil)
+	committer = &recordingCommitter{}
+	shutdown, stop := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer stop()
+	consumer.processMessage(shutdown, committer, testRecord(t, 0, 8))
+
+	if committed := committer.committed(); len(committed) != 0 {
+		t.Fatalf("got commits %v, want none without a dead-letter topic", committed)
+	}
+}
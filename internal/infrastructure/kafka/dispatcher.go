@@ -0,0 +1,63 @@
+package kafka
+
+import (
+	"log/slog"
+	"sync"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// partitionQueueSize adalah jumlah record yang boleh menunggu per partition
+const partitionQueueSize = 256
+
+// partitionDispatcher memproses record dari partition berbeda secara paralel, tetapi
+// record dalam satu partition tetap diproses berurutan oleh satu worker. Karena producer
+// memakai session ID sebagai key, semua event satu session selalu berada di partition
+// yang sama sehingga urutan per session terjaga.
+type partitionDispatcher struct {
+	process func(m kafka.Message)
+	workers map[int]chan kafka.Message
+	wg      sync.WaitGroup
+	logger  *slog.Logger
+}
+
+func newPartitionDispatcher(process func(m kafka.Message), logger *slog.Logger) *partitionDispatcher {
+	return &partitionDispatcher{
+		process: process,
+		workers: make(map[int]chan kafka.Message),
+		logger:  logger,
+	}
+}
+
+// dispatch hanya dipanggil dari satu goroutine (fetch loop reader)
+func (d *partitionDispatcher) dispatch(m kafka.Message) {
+	queue, exists := d.workers[m.Partition]
+	if !exists {
+		queue = make(chan kafka.Message, partitionQueueSize)
+		d.workers[m.Partition] = queue
+		d.wg.Add(1)
+		go d.run(m.Topic, m.Partition, queue)
+	}
+	queue <- m
+}
+
+func (d *partitionDispatcher) run(topic string, partition int, queue <-chan kafka.Message) {
+	defer d.wg.Done()
+	defer func() {
+		if r := recover(); r != nil {
+			d.logger.Error("Recovered from panic in Kafka worker", "topic", topic, "partition", partition, "panic", r)
+		}
+	}()
+
+	for m := range queue {
+		d.process(m)
+	}
+}
+
+// stop menunggu semua record yang sudah di-dispatch selesai diproses
+func (d *partitionDispatcher) stop() {
+	for _, queue := range d.workers {
+		close(queue)
+	}
+	d.wg.Wait()
+}
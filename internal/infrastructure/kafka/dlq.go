@@ -0,0 +1,283 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"livechat-ws/internal/domain"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// Header yang ditambahkan ke record di dead-letter topic
+const (
+	HeaderDLQOriginalTopic     = "dlq-original-topic"
+	HeaderDLQOriginalPartition = "dlq-original-partition"
+	HeaderDLQOriginalOffset    = "dlq-original-offset"
+	HeaderDLQError             = "dlq-error"
+	HeaderDLQFailedAt          = "dlq-failed-at"
+)
+
+const (
+	dlqHeaderPrefix  = "dlq-"
+	dlqReadTimeout   = 10 * time.Second
+	dlqMaxRecordSize = 10e6
+)
+
+var ErrDeadLetterNotFound = errors.New("dead letter not found")
+
+// DeadLetterQueue menyimpan record Kafka yang gagal di-decode atau di-handle ke topic
+// terpisah, dan bisa mengirim ulang record tersebut ke topic asalnya
+type DeadLetterQueue struct {
+	config Config
+	topic  string
+	writer messageWriter
+}
+
+func NewDeadLetterQueue(config Config) *DeadLetterQueue {
+	writer := &kafka.Writer{
+		Addr:         kafka.TCP(config.Brokers...),
+		Transport:    config.transport(),
+		Balancer:     &kafka.Hash{},
+		BatchSize:    1,
+		RequiredAcks: kafka.RequireAll, // Record di DLQ tidak boleh hilang
+		WriteTimeout: config.Producer.WriteTimeout,
+		ReadTimeout:  config.Producer.ReadTimeout,
+	}
+	return &DeadLetterQueue{config: config, topic: config.Topics.DeadLetterName(), writer: writer}
+}
+
+func (d *DeadLetterQueue) Topic() string {
+	return d.topic
+}
+
+// Send menulis record ke dead-letter topic dengan key, value dan header aslinya,
+// ditambah asal record dan penyebab kegagalan
+func (d *DeadLetterQueue) Send(ctx context.Context, m kafka.Message, cause error) error {
+	headers := make([]kafka.Header, 0, len(m.Headers)+5)
+	for _, header := range m.Headers {
+		if !strings.HasPrefix(header.Key, dlqHeaderPrefix) {
+			headers = append(headers, header)
+		}
+	}
+	headers = append(headers,
+		kafka.Header{Key: HeaderDLQOriginalTopic, Value: []byte(m.Topic)},
+		kafka.Header{Key: HeaderDLQOriginalPartition, Value: []byte(strconv.Itoa(m.Partition))},
+		kafka.Header{Key: HeaderDLQOriginalOffset, Value: []byte(strconv.FormatInt(m.Offset, 10))},
+		kafka.Header{Key: HeaderDLQError, Value: []byte(cause.Error())},
+		kafka.Header{Key: HeaderDLQFailedAt, Value: []byte(time.Now().UTC().Format(time.RFC3339Nano))},
+	)
+
+	return d.writer.WriteMessages(ctx, kafka.Message{
+		Topic:   d.topic,
+		Key:     m.Key,
+		Value:   m.Value,
+		Headers: headers,
+	})
+}
+
+// List mengembalikan maksimal limit record terbaru dari dead-letter topic, terbaru lebih dulu
+func (d *DeadLetterQueue) List(ctx context.Context, limit int) ([]domain.DeadLetterEntry, error) {
+	partitions, err := d.partitions(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]domain.DeadLetterEntry, 0)
+	for _, partition := range partitions {
+		messages, err := d.readTail(ctx, partition, int64(limit))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read dead letters from partition %d: %w", partition, err)
+		}
+		for _, m := range messages {
+			entries = append(entries, toDeadLetterEntry(m))
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].FailedAt.After(entries[j].FailedAt)
+	})
+	if len(entries) > limit {
+		entries = entries[:limit]
+	}
+	return entries, nil
+}
+
+// Reinject mengirim ulang satu record DLQ ke topic asalnya. Record tetap ada di DLQ
+// karena Kafka tidak mendukung penghapusan satu record.
+func (d *DeadLetterQueue) Reinject(ctx context.Context, partition int, offset int64) (*domain.DeadLetterEntry, error) {
+	m, err := d.read(ctx, partition, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	entry := toDeadLetterEntry(*m)
+	if entry.OriginalTopic == "" {
+		return nil, fmt.Errorf("dead letter %d/%d has no original topic", partition, offset)
+	}
+
+	headers := make([]kafka.Header, 0, len(m.Headers))
+	for _, header := range m.Headers {
+		if !strings.HasPrefix(header.Key, dlqHeaderPrefix) {
+			headers = append(headers, header)
+		}
+	}
+
+	if err := d.writer.WriteMessages(ctx, kafka.Message{
+		Topic:   entry.OriginalTopic,
+		Key:     m.Key,
+		Value:   m.Value,
+		Headers: headers,
+	}); err != nil {
+		return nil, err
+	}
+
+	d.config.logger().Info("Re-injected dead letter", "partition", partition, "offset", offset, "topic", entry.OriginalTopic)
+	return &entry, nil
+}
+
+func (d *DeadLetterQueue) Close() error {
+	return d.writer.Close()
+}
+
+func (d *DeadLetterQueue) partitions(ctx context.Context) ([]int, error) {
+	partitions, err := readPartitions(ctx, d.config, d.topic)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]int, len(partitions))
+	for i, partition := range partitions {
+		ids[i] = partition.ID
+	}
+	return ids, nil
+}
+
+func (d *DeadLetterQueue) dialPartition(ctx context.Context, partition int) (*kafka.Conn, error) {
+	dialer := d.config.dialer()
+
+	var lastErr error
+	for _, address := range d.config.Brokers {
+		conn, err := dialer.DialLeader(ctx, "tcp", address, d.topic, partition)
+		if err == nil {
+			deadline, ok := ctx.Deadline()
+			if !ok {
+				deadline = time.Now().Add(dlqReadTimeout)
+			}
+			conn.SetDeadline(deadline)
+			return conn, nil
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// readTail membaca maksimal limit record terakhir dari satu partition
+func (d *DeadLetterQueue) readTail(ctx context.Context, partition int, limit int64) ([]kafka.Message, error) {
+	conn, err := d.dialPartition(ctx, partition)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	first, last, err := conn.ReadOffsets()
+	if err != nil {
+		return nil, err
+	}
+
+	start := last - limit
+	if start < first {
+		start = first
+	}
+	if start >= last {
+		return nil, nil
+	}
+
+	if _, err := conn.Seek(start, kafka.SeekAbsolute); err != nil {
+		return nil, err
+	}
+
+	batch := conn.ReadBatch(1, dlqMaxRecordSize)
+	defer batch.Close()
+
+	messages := make([]kafka.Message, 0, last-start)
+	for {
+		m, err := batch.ReadMessage()
+		if err != nil {
+			break
+		}
+		messages = append(messages, m)
+		if m.Offset >= last-1 {
+			break
+		}
+	}
+	return messages, nil
+}
+
+func (d *DeadLetterQueue) read(ctx context.Context, partition int, offset int64) (*kafka.Message, error) {
+	conn, err := d.dialPartition(ctx, partition)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	first, last, err := conn.ReadOffsets()
+	if err != nil {
+		return nil, err
+	}
+	if offset < first || offset >= last {
+		return nil, ErrDeadLetterNotFound
+	}
+
+	if _, err := conn.Seek(offset, kafka.SeekAbsolute); err != nil {
+		return nil, err
+	}
+
+	batch := conn.ReadBatch(1, dlqMaxRecordSize)
+	defer batch.Close()
+
+	m, err := batch.ReadMessage()
+	if err != nil {
+		return nil, err
+	}
+	if m.Offset != offset {
+		// Offset sudah di-compact atau dihapus
+		return nil, ErrDeadLetterNotFound
+	}
+	return &m, nil
+}
+
+func toDeadLetterEntry(m kafka.Message) domain.DeadLetterEntry {
+	entry := domain.DeadLetterEntry{
+		Partition: m.Partition,
+		Offset:    m.Offset,
+		Key:       string(m.Key),
+		Value:     string(m.Value),
+	}
+
+	for _, header := range m.Headers {
+		value := string(header.Value)
+		switch header.Key {
+		case HeaderDLQOriginalTopic:
+			entry.OriginalTopic = value
+		case HeaderDLQOriginalPartition:
+			entry.OriginalPartition, _ = strconv.Atoi(value)
+		case HeaderDLQOriginalOffset:
+			entry.OriginalOffset, _ = strconv.ParseInt(value, 10, 64)
+		case HeaderDLQError:
+			entry.Error = value
+		case HeaderDLQFailedAt:
+			entry.FailedAt, _ = time.Parse(time.RFC3339Nano, value)
+		}
+	}
+
+	if entry.FailedAt.IsZero() {
+		entry.FailedAt = m.Time
+	}
+	return entry
+}
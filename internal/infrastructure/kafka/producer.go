@@ -2,69 +2,111 @@ package kafka
 
 import (
 	"context"
-	"encoding/json"
-	"log"
-	"time"
+	"log/slog"
 
 	"livechat-ws/internal/domain"
+	"livechat-ws/internal/infrastructure/broker"
+	"livechat-ws/internal/infrastructure/metrics"
+	"livechat-ws/internal/infrastructure/tracing"
 
 	"github.com/segmentio/kafka-go"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// messageWriter adalah bagian kafka.Writer yang dipakai producer, test menggantinya
+// supaya record yang ditulis bisa diperiksa tanpa broker
+type messageWriter interface {
+	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+	Close() error
+}
+
 type KafkaProducer struct {
-	Writer *kafka.Writer
+	writer     messageWriter
+	topics     TopicConfig
+	codec      broker.Codec
+	instanceID string
+	logger     *slog.Logger
 }
 
-func NewKafkaProducer(broker, defaultTopic string) *KafkaProducer {
+func NewKafkaProducer(config Config, instanceID string) *KafkaProducer {
 	writer := &kafka.Writer{
-		Addr:     kafka.TCP(broker),
-		Balancer: &kafka.LeastBytes{},
-		// Optimize for low latency
-		BatchSize:    1,                    // Send immediately, don't batch
-		BatchTimeout: 0 * time.Millisecond, // 1ms timeout
-		RequiredAcks: 1,                    // Wait for leader acknowledgment only
-		Async:        false,                // Synchronous for immediate sending
+		Addr:      kafka.TCP(config.Brokers...),
+		Transport: config.transport(),
+		// Record di-key dengan session ID, Hash balancer memastikan semua event satu
+		// session masuk ke partition yang sama sehingga urutannya terjaga
+		Balancer:     &kafka.Hash{},
+		BatchSize:    config.Producer.BatchSize,
+		BatchTimeout: config.Producer.BatchTimeout,
+		RequiredAcks: config.Producer.RequiredAcks,
+		Compression:  config.Producer.Compression,
+		WriteTimeout: config.Producer.WriteTimeout,
+		ReadTimeout:  config.Producer.ReadTimeout,
+		Async:        false, // Synchronous supaya error publish bisa dilaporkan ke client
 	}
-	return &KafkaProducer{Writer: writer}
+	return &KafkaProducer{writer: writer, topics: config.Topics, codec: config.Codec, instanceID: instanceID, logger: config.logger()}
 }
 
-func (k *KafkaProducer) SendMessage(ctx context.Context, message interface{}) error {
-	data, err := json.Marshal(message)
-	if err != nil {
-		return err
+func (k *KafkaProducer) SendMessage(ctx context.Context, event *domain.EventEnvelope) (err error) {
+	// Determine topic based on event type
+	topic := k.topics.Name(broker.TopicForEvent(event))
+
+	ctx, span := tracing.Tracer().Start(ctx, "kafka.produce "+topic,
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			semconv.MessagingSystemKafka,
+			semconv.MessagingDestinationName(topic),
+			tracing.AttrSessionID.String(event.SessionID),
+			tracing.AttrEventID.String(event.ID),
+			tracing.AttrEventType.String(event.Type),
+		))
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	event = broker.WithOrigin(event, k.instanceID)
+	headers := []kafka.Header{
+		{Key: broker.HeaderOriginInstance, Value: []byte(k.instanceID)},
 	}
 
-	// Determine topic based on message type
-	topic := k.getTopicForMessage(message)
+	var data []byte
+	if k.codec.IsCloudEventsBinary() {
+		// Atribut CloudEvents di header, value hanya berisi data event
+		data = event.Payload
+		headers = append(headers, cloudEventHeaders(event, k.codec.CloudEventsSource)...)
+	} else {
+		data, err = k.codec.Encode(event)
+		if err != nil {
+			return err
+		}
+		if k.codec.Format == broker.FormatCloudEvents {
+			headers = append(headers, kafka.Header{Key: headerContentType, Value: []byte(broker.CloudEventsContentType)})
+		}
+	}
+
+	// Trace context ikut di header supaya consumer melanjutkan trace yang sama
+	tracing.Inject(ctx, headerCarrier{&headers})
 
 	msg := kafka.Message{
-		Topic: topic,
-		Value: data,
+		Topic:   topic,
+		Key:     []byte(event.SessionID),
+		Value:   data,
+		Headers: headers,
 	}
 
-	err = k.Writer.WriteMessages(ctx, msg)
+	err = k.writer.WriteMessages(ctx, msg)
 	if err != nil {
-		log.Printf("Failed to send message to Kafka topic %s: %v", topic, err)
+		metrics.KafkaProduceErrors.WithLabelValues(topic).Inc()
+		k.logger.Error("Failed to send message to Kafka", "topic", topic, "session_id", event.SessionID, "event_id", event.ID, "error", err)
 		return err
 	}
+	metrics.KafkaProduced.WithLabelValues(topic).Inc()
 
-	log.Printf("Message sent to Kafka topic %s successfully", topic)
+	k.logger.Debug("Message sent to Kafka", "topic", topic, "session_id", event.SessionID, "event_id", event.ID)
 	return nil
 }
 
-func (k *KafkaProducer) getTopicForMessage(message interface{}) string {
-	switch message.(type) {
-	case domain.ChatMessage:
-		return "chat-messages"
-	case domain.TypingMessage:
-		return "typing-indicators"
-	case domain.ConnectionStatusMessage:
-		return "connection-status"
-	default:
-		return "chat-messages" // fallback to default topic
-	}
-}
-
 func (k *KafkaProducer) Close() error {
-	return k.Writer.Close()
+	return k.writer.Close()
 }
@@ -0,0 +1,88 @@
+package kafka
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/segmentio/kafka-go/sasl/scram"
+)
+
+// Mekanisme SASL yang didukung
+const (
+	SASLPlain       = "PLAIN"
+	SASLScramSHA256 = "SCRAM-SHA-256"
+	SASLScramSHA512 = "SCRAM-SHA-512"
+)
+
+// TLSOptions berisi lokasi file sertifikat untuk koneksi TLS ke broker
+type TLSOptions struct {
+	CAFile             string // CA untuk memverifikasi broker, kosong berarti CA sistem
+	CertFile           string // Sertifikat client untuk mutual TLS
+	KeyFile            string
+	ServerName         string // Override SNI/verifikasi hostname broker
+	InsecureSkipVerify bool
+}
+
+// NewTLSConfig membuat tls.Config dari file CA dan sertifikat client
+func NewTLSConfig(opts TLSOptions) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         opts.ServerName,
+		InsecureSkipVerify: opts.InsecureSkipVerify,
+	}
+
+	if opts.CAFile != "" {
+		caPEM, err := os.ReadFile(opts.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("no certificates found in CA file %s", opts.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if (opts.CertFile == "") != (opts.KeyFile == "") {
+		return nil, errors.New("client certificate and key must be configured together")
+	}
+	if opts.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+// NewSASLMechanism membuat mekanisme SASL dari nama PLAIN, SCRAM-SHA-256 atau SCRAM-SHA-512.
+// Nama kosong berarti tanpa SASL.
+func NewSASLMechanism(mechanism, username, password string) (sasl.Mechanism, error) {
+	mechanism = strings.ToUpper(strings.TrimSpace(mechanism))
+	if mechanism == "" {
+		return nil, nil
+	}
+	if username == "" {
+		return nil, errors.New("SASL username is required")
+	}
+
+	switch mechanism {
+	case SASLPlain:
+		return plain.Mechanism{Username: username, Password: password}, nil
+	case SASLScramSHA256:
+		return scram.Mechanism(scram.SHA256, username, password)
+	case SASLScramSHA512:
+		return scram.Mechanism(scram.SHA512, username, password)
+	default:
+		return nil, fmt.Errorf("unsupported SASL mechanism %q (expected %s, %s or %s)",
+			mechanism, SASLPlain, SASLScramSHA256, SASLScramSHA512)
+	}
+}
@@ -0,0 +1,475 @@
+package kafka
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha512"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/protocol"
+	"github.com/segmentio/kafka-go/protocol/apiversions"
+	"github.com/segmentio/kafka-go/protocol/metadata"
+	"github.com/segmentio/kafka-go/protocol/saslauthenticate"
+	"github.com/segmentio/kafka-go/protocol/saslhandshake"
+	"github.com/xdg/scram"
+)
+
+func TestNewSASLMechanism(t *testing.T) {
+	tests := []struct {
+		name      string
+		mechanism string
+		username  string
+		wantName  string
+		wantErr   string
+	}{
+		{name: "empty disables SASL", mechanism: "", username: "user"},
+		{name: "whitespace disables SASL", mechanism: "   ", username: ""},
+		{name: "plain", mechanism: "PLAIN", username: "user", wantName: SASLPlain},
+		{name: "plain lower case", mechanism: "plain", username: "user", wantName: SASLPlain},
+		{name: "scram 256", mechanism: "SCRAM-SHA-256", username: "user", wantName: SASLScramSHA256},
+		{name: "scram 256 mixed case and spaces", mechanism: " scram-sha-256 ", username: "user", wantName: SASLScramSHA256},
+		{name: "scram 512", mechanism: "SCRAM-SHA-512", username: "user", wantName: SASLScramSHA512},
+		{name: "scram 512 lower case", mechanism: "scram-sha-512", username: "user", wantName: SASLScramSHA512},
+		{name: "missing username", mechanism: "PLAIN", username: "", wantErr: "username is required"},
+		{name: "unknown mechanism", mechanism: "GSSAPI", username: "user", wantErr: "unsupported SASL mechanism"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mechanism, err := NewSASLMechanism(tt.mechanism, tt.username, "secret")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.wantName == "" {
+				if mechanism != nil {
+					t.Fatalf("got mechanism %s, want none", mechanism.Name())
+				}
+				return
+			}
+			if mechanism == nil || mechanism.Name() != tt.wantName {
+				t.Fatalf("got mechanism %v, want %s", mechanism, tt.wantName)
+			}
+		})
+	}
+}
+
+// testPKI berisi CA, sertifikat broker (localhost) dan sertifikat client yang ditulis ke file PEM
+type testPKI struct {
+	caFile, certFile, keyFile string
+	caPool                    *x509.CertPool
+	server                    tls.Certificate
+}
+
+func newTestPKI(t *testing.T) testPKI {
+	t.Helper()
+	dir := t.TempDir()
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caCert, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	issue := func(serial int64, commonName string, usage x509.ExtKeyUsage) ([]byte, []byte) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(serial),
+			Subject:      pkix.Name{CommonName: commonName},
+			DNSNames:     []string{commonName},
+			IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, caCert, &key.PublicKey, caKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keyDER, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+			pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	}
+
+	write := func(name string, data []byte) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	serverCert, serverKey := issue(2, "localhost", x509.ExtKeyUsageServerAuth)
+	server, err := tls.X509KeyPair(serverCert, serverKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientCert, clientKey := issue(3, "livechat-ws", x509.ExtKeyUsageClientAuth)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(caCert)
+
+	return testPKI{
+		caFile:   write("ca.pem", pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER})),
+		certFile: write("client.pem", clientCert),
+		keyFile:  write("client-key.pem", clientKey),
+		caPool:   pool,
+		server:   server,
+	}
+}
+
+func TestNewTLSConfig(t *testing.T) {
+	pki := newTestPKI(t)
+	emptyFile := filepath.Join(t.TempDir(), "empty.pem")
+	if err := os.WriteFile(emptyFile, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		opts     TLSOptions
+		wantErr  string
+		wantCA   bool
+		wantCert bool
+	}{
+		{name: "system CA", opts: TLSOptions{}},
+		{name: "custom CA", opts: TLSOptions{CAFile: pki.caFile}, wantCA: true},
+		{name: "mutual TLS", opts: TLSOptions{CAFile: pki.caFile, CertFile: pki.certFile, KeyFile: pki.keyFile}, wantCA: true, wantCert: true},
+		{name: "missing CA file", opts: TLSOptions{CAFile: filepath.Join(t.TempDir(), "missing.pem")}, wantErr: "failed to read CA file"},
+		{name: "CA file without certificates", opts: TLSOptions{CAFile: emptyFile}, wantErr: "no certificates found"},
+		{name: "cert without key", opts: TLSOptions{CertFile: pki.certFile}, wantErr: "must be configured together"},
+		{name: "key without cert", opts: TLSOptions{KeyFile: pki.keyFile}, wantErr: "must be configured together"},
+		{name: "mismatched key pair", opts: TLSOptions{CertFile: pki.certFile, KeyFile: pki.caFile}, wantErr: "failed to load client certificate"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tlsConfig, err := NewTLSConfig(tt.opts)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tlsConfig.MinVersion != tls.VersionTLS12 {
+				t.Errorf("got MinVersion %x, want TLS 1.2", tlsConfig.MinVersion)
+			}
+			if (tlsConfig.RootCAs != nil) != tt.wantCA {
+				t.Errorf("got RootCAs %v, want custom CA %v", tlsConfig.RootCAs != nil, tt.wantCA)
+			}
+			if (len(tlsConfig.Certificates) == 1) != tt.wantCert {
+				t.Errorf("got %d client certificates, want client cert %v", len(tlsConfig.Certificates), tt.wantCert)
+			}
+		})
+	}
+}
+
+// TestTLSConfigMutualHandshake memakai listener TLS lokal sebagai pengganti broker yang
+// mewajibkan sertifikat client dari CA yang sama
+func TestTLSConfigMutualHandshake(t *testing.T) {
+	pki := newTestPKI(t)
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{pki.server},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pki.caPool,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	serverErr := make(chan error, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			serverErr <- err
+			return
+		}
+		defer conn.Close()
+		serverErr <- conn.(*tls.Conn).Handshake()
+	}()
+
+	tlsConfig, err := NewTLSConfig(TLSOptions{
+		CAFile:     pki.caFile,
+		CertFile:   pki.certFile,
+		KeyFile:    pki.keyFile,
+		ServerName: "localhost",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: 5 * time.Second}, "tcp", listener.Addr().String(), tlsConfig)
+	if err != nil {
+		t.Fatalf("client handshake: %v", err)
+	}
+	defer conn.Close()
+
+	if err := <-serverErr; err != nil {
+		t.Fatalf("server handshake: %v", err)
+	}
+}
+
+// standInBroker adalah listener TLS yang menjawab ApiVersions, SaslHandshake,
+// SaslAuthenticate dan Metadata seperti broker Kafka dengan satu user SASL
+type standInBroker struct {
+	listener  net.Listener
+	mechanism string
+	username  string
+	password  string
+
+	mutex         sync.Mutex
+	mechanisms    []string
+	authenticated []string
+}
+
+func newStandInBroker(t *testing.T, server tls.Certificate, mechanism, username, password string) *standInBroker {
+	t.Helper()
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{server}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &standInBroker{listener: listener, mechanism: mechanism, username: username, password: password}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go b.serve(conn)
+		}
+	}()
+	return b
+}
+
+func (b *standInBroker) addr() string {
+	return b.listener.Addr().String()
+}
+
+func (b *standInBroker) received() (mechanisms, authenticated []string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return append([]string(nil), b.mechanisms...), append([]string(nil), b.authenticated...)
+}
+
+func (b *standInBroker) serve(conn net.Conn) {
+	defer conn.Close()
+
+	var scramConversation *scram.ServerConversation
+	for {
+		version, correlationID, _, msg, err := protocol.ReadRequest(conn)
+		if err != nil {
+			return
+		}
+
+		var response protocol.Message
+		switch req := msg.(type) {
+		case *apiversions.Request:
+			response = &apiversions.Response{ApiKeys: []apiversions.ApiKeyResponse{
+				{ApiKey: int16(protocol.ApiVersions), MinVersion: 0, MaxVersion: 0},
+				{ApiKey: int16(protocol.Metadata), MinVersion: 1, MaxVersion: 1},
+				{ApiKey: int16(protocol.SaslHandshake), MinVersion: 1, MaxVersion: 1},
+				{ApiKey: int16(protocol.SaslAuthenticate), MinVersion: 0, MaxVersion: 0},
+			}}
+
+		case *saslhandshake.Request:
+			b.mutex.Lock()
+			b.mechanisms = append(b.mechanisms, req.Mechanism)
+			b.mutex.Unlock()
+
+			res := &saslhandshake.Response{Mechanisms: []string{b.mechanism}}
+			if req.Mechanism != b.mechanism {
+				res.ErrorCode = int16(kafka.UnsupportedSASLMechanism)
+			}
+			if strings.HasPrefix(b.mechanism, "SCRAM-") {
+				scramConversation = b.newScramServer().NewConversation()
+			}
+			response = res
+
+		case *saslauthenticate.Request:
+			res := &saslauthenticate.Response{}
+			var username string
+			if scramConversation != nil {
+				challenge, err := scramConversation.Step(string(req.AuthBytes))
+				res.AuthBytes = []byte(challenge)
+				if err != nil {
+					res.ErrorCode = int16(kafka.SASLAuthenticationFailed)
+				} else if scramConversation.Valid() {
+					username = scramConversation.Username()
+				}
+			} else {
+				// PLAIN: authzid NUL username NUL password
+				parts := strings.Split(string(req.AuthBytes), "\x00")
+				if len(parts) == 3 && parts[1] == b.username && parts[2] == b.password {
+					username = parts[1]
+				} else {
+					res.ErrorCode = int16(kafka.SASLAuthenticationFailed)
+				}
+			}
+			if username != "" {
+				b.mutex.Lock()
+				b.authenticated = append(b.authenticated, username)
+				b.mutex.Unlock()
+			}
+			response = res
+
+		case *metadata.Request:
+			host, port, _ := net.SplitHostPort(b.addr())
+			portNumber, _ := strconv.Atoi(port)
+			response = &metadata.Response{
+				Brokers:      []metadata.ResponseBroker{{NodeID: 1, Host: host, Port: int32(portNumber)}},
+				ControllerID: 1,
+			}
+
+		default:
+			return
+		}
+
+		if err := protocol.WriteResponse(conn, version, correlationID, response); err != nil {
+			return
+		}
+	}
+}
+
+func (b *standInBroker) newScramServer() *scram.Server {
+	hash := scram.SHA256
+	if b.mechanism == SASLScramSHA512 {
+		hash = scram.HashGeneratorFcn(sha512.New)
+	}
+	client, _ := hash.NewClient(b.username, b.password, "")
+	credentials := client.GetStoredCredentials(scram.KeyFactors{Salt: "livechat-ws", Iters: 4096})
+	server, _ := hash.NewServer(func(username string) (scram.StoredCredentials, error) {
+		if username != b.username {
+			return scram.StoredCredentials{}, fmt.Errorf("unknown user %s", username)
+		}
+		return credentials, nil
+	})
+	return server
+}
+
+// TestClientAuthenticatesOverTLS menjalankan Dialer (reader consumer) dan Transport
+// (producer) yang dibuat dari Config terhadap stand-in broker
+func TestClientAuthenticatesOverTLS(t *testing.T) {
+	pki := newTestPKI(t)
+	otherPKI := newTestPKI(t)
+
+	tests := []struct {
+		name      string
+		mechanism string
+		password  string
+		tls       TLSOptions
+		wantErr   string
+	}{
+		{name: "plain", mechanism: SASLPlain, password: "secret", tls: TLSOptions{CAFile: pki.caFile}},
+		{name: "scram sha 256", mechanism: SASLScramSHA256, password: "secret", tls: TLSOptions{CAFile: pki.caFile}},
+		{name: "scram sha 512", mechanism: SASLScramSHA512, password: "secret", tls: TLSOptions{CAFile: pki.caFile}},
+		{name: "plain wrong password", mechanism: SASLPlain, password: "wrong", tls: TLSOptions{CAFile: pki.caFile}, wantErr: "SASL Authentication Failed"},
+		{name: "scram wrong password", mechanism: SASLScramSHA512, password: "wrong", tls: TLSOptions{CAFile: pki.caFile}, wantErr: "SASL Authentication Failed"},
+		{name: "wrong CA", mechanism: SASLPlain, password: "secret", tls: TLSOptions{CAFile: otherPKI.caFile}, wantErr: "certificate signed by unknown authority"},
+		{name: "wrong server name", mechanism: SASLPlain, password: "secret", tls: TLSOptions{CAFile: pki.caFile, ServerName: "kafka.example.com"}, wantErr: "not kafka.example.com"},
+	}
+
+	for _, tt := range tests {
+		for _, client := range []string{"dialer", "transport"} {
+			t.Run(tt.name+"/"+client, func(t *testing.T) {
+				broker := newStandInBroker(t, pki.server, tt.mechanism, "livechat", "secret")
+
+				tlsConfig, err := NewTLSConfig(tt.tls)
+				if err != nil {
+					t.Fatal(err)
+				}
+				mechanism, err := NewSASLMechanism(tt.mechanism, "livechat", tt.password)
+				if err != nil {
+					t.Fatal(err)
+				}
+				config := Config{Brokers: []string{broker.addr()}, DialTimeout: 5 * time.Second, TLS: tlsConfig, SASL: mechanism}
+
+				ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+				defer cancel()
+
+				if client == "dialer" {
+					var conn *kafka.Conn
+					conn, err = config.dialer().DialContext(ctx, "tcp", broker.addr())
+					if err == nil {
+						conn.Close()
+					}
+				} else {
+					transport := config.transport()
+					_, err = transport.RoundTrip(ctx, kafka.TCP(broker.addr()), &metadata.Request{})
+					transport.CloseIdleConnections()
+				}
+
+				mechanisms, authenticated := broker.received()
+				if tt.wantErr != "" {
+					if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+						t.Fatalf("got error %v, want %q", err, tt.wantErr)
+					}
+					if len(authenticated) != 0 {
+						t.Fatalf("broker accepted %v", authenticated)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatalf("connect: %v", err)
+				}
+				if len(mechanisms) == 0 || mechanisms[0] != tt.mechanism {
+					t.Fatalf("broker got mechanisms %v, want %s", mechanisms, tt.mechanism)
+				}
+				if len(authenticated) == 0 || authenticated[0] != "livechat" {
+					t.Fatalf("broker authenticated %v, want livechat", authenticated)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package kafka
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+
+	"livechat-ws/internal/domain"
+	"livechat-ws/internal/infrastructure/broker"
+	"livechat-ws/internal/infrastructure/tracing"
+
+	"github.com/segmentio/kafka-go"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// setupTestTracing memasang propagator W3C dan provider yang merekam span ke memori
+func setupTestTracing(t *testing.T) *tracetest.InMemoryExporter {
+	t.Helper()
+
+	if _, err := tracing.Setup(context.Background(), tracing.Options{Exporter: tracing.ExporterNone}); err != nil {
+		t.Fatal(err)
+	}
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	otel.SetTracerProvider(provider)
+
+	t.Cleanup(func() {
+		provider.Shutdown(context.Background())
+		if _, err := tracing.Setup(context.Background(), tracing.Options{Exporter: tracing.ExporterNone}); err != nil {
+			t.Fatal(err)
+		}
+	})
+	return exporter
+}
+
+func TestHeaderCarrierRoundTrip(t *testing.T) {
+	setupTestTracing(t)
+
+	parent := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{0x4b, 0xf9, 0x2f, 0x35, 0x77, 0xb3, 0x4d, 0xa6},
+		SpanID:     trace.SpanID{0x00, 0xf0, 0x67, 0xaa, 0x0b, 0xa9, 0x02, 0xb7},
+		TraceFlags: trace.FlagsSampled,
+	})
+	ctx := trace.ContextWithSpanContext(context.Background(), parent)
+
+	// traceparent lama dari record yang di-publish ulang harus ditimpa, bukan diduplikasi
+	headers := []kafka.Header{
+		{Key: broker.HeaderOriginInstance, Value: []byte("ws-1")},
+		{Key: "traceparent", Value: []byte("00-00000000000000000000000000000001-0000000000000001-01")},
+	}
+	tracing.Inject(ctx, headerCarrier{&headers})
+
+	var traceparents int
+	for _, header := range headers {
+		if header.Key == "traceparent" {
+			traceparents++
+		}
+	}
+	if traceparents != 1 {
+		t.Fatalf("got %d traceparent headers, want 1", traceparents)
+	}
+	want := "00-" + parent.TraceID().String() + "-" + parent.SpanID().String() + "-01"
+	if got := (headerCarrier{&headers}).Get("traceparent"); got != want {
+		t.Fatalf("got traceparent %q, want %q", got, want)
+	}
+	if got := (headerCarrier{&headers}).Get(broker.HeaderOriginInstance); got != "ws-1" {
+		t.Fatalf("other headers changed: got origin %q", got)
+	}
+
+	extracted := trace.SpanContextFromContext(tracing.Extract(context.Background(), headerCarrier{&headers}))
+	if extracted.TraceID() != parent.TraceID() || extracted.SpanID() != parent.SpanID() {
+		t.Fatalf("got span context %s/%s, want %s/%s", extracted.TraceID(), extracted.SpanID(), parent.TraceID(), parent.SpanID())
+	}
+	if !extracted.IsRemote() || !extracted.IsSampled() {
+		t.Fatalf("extracted span context remote=%v sampled=%v, want both", extracted.IsRemote(), extracted.IsSampled())
+	}
+}
+
+// recordingWriter menyimpan record yang ditulis producer sebagai pengganti kafka.Writer.
+// Jika err diisi, penulisan gagal dan tidak ada record yang disimpan.
+type recordingWriter struct {
+	messages []kafka.Message
+	err      error
+}
+
+func (w *recordingWriter) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+	if w.err != nil {
+		return w.err
+	}
+	w.messages = append(w.messages, msgs...)
+	return nil
+}
+
+func (w *recordingWriter) Close() error {
+	return nil
+}
+
+func TestTraceContextSurvivesProducerToConsumer(t *testing.T) {
+	exporter := setupTestTracing(t)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	config := Config{Brokers: []string{"127.0.0.1:1"}, Codec: broker.Codec{Format: broker.FormatEnvelope}, Logger: logger}
+
+	writer := &recordingWriter{}
+	producer := &KafkaProducer{writer: writer, topics: config.Topics, codec: config.Codec, instanceID: "chat-api", logger: logger}
+
+	ctx, request := otel.Tracer("test").Start(context.Background(), "send_message")
+	event, err := broker.NewEvent(broker.EventNewMessage, "event-1", "session-1", map[string]string{"message": "hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := producer.SendMessage(ctx, event); err != nil {
+		t.Fatal(err)
+	}
+	request.End()
+	if len(writer.messages) != 1 {
+		t.Fatalf("got %d records written, want 1", len(writer.messages))
+	}
+
+	var handled trace.SpanContext
+	handler := broker.HandlerFunc(func(ctx context.Context, event *domain.EventEnvelope) error {
+		handled = trace.SpanContextFromContext(ctx)
+		return nil
+	})
+	consumer := NewKafkaConsumer(config, nil, handler, "ws-1", nil)
+
+	consumer.processMessage(context.Background(), &recordingCommitter{}, writer.messages[0])
+
+	if handled.TraceID() != request.SpanContext().TraceID() {
+		t.Fatalf("handler got trace %s, want producer trace %s", handled.TraceID(), request.SpanContext().TraceID())
+	}
+
+	spans := map[string]tracetest.SpanStub{}
+	for _, span := range exporter.GetSpans() {
+		spans[span.SpanKind.String()] = span
+	}
+	produce, okProduce := spans[trace.SpanKindProducer.String()]
+	consume, okConsume := spans[trace.SpanKindConsumer.String()]
+	if !okProduce || !okConsume {
+		t.Fatalf("got spans %v, want produce and consume spans", spans)
+	}
+	if consume.Parent.SpanID() != produce.SpanContext.SpanID() {
+		t.Fatalf("consume span parent %s, want produce span %s", consume.Parent.SpanID(), produce.SpanContext.SpanID())
+	}
+	if handled.SpanID() != consume.SpanContext.SpanID() {
+		t.Fatalf("handler ran under span %s, want consume span %s", handled.SpanID(), consume.SpanContext.SpanID())
+	}
+}
@@ -0,0 +1,79 @@
+// Package logging menyiapkan logger slog aplikasi beserta redaksi data pribadi
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// Format output log
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Field berisi data yang ditulis user, diredaksi kecuali redaksi dimatikan
+const (
+	KeyMessage     = "message"
+	KeyAttachments = "attachments"
+	KeyPayload     = "payload"
+)
+
+const redacted = "[REDACTED]"
+
+var sensitiveKeys = map[string]bool{
+	KeyMessage:     true,
+	KeyAttachments: true,
+	KeyPayload:     true,
+}
+
+// Options mengatur logger yang dibuat New
+type Options struct {
+	Level  string // debug, info, warn, error
+	Format string // FormatText atau FormatJSON
+	// Redact mengganti isi pesan, attachment, dan payload event dengan [REDACTED]
+	Redact bool
+}
+
+// New membuat logger yang menulis ke w sesuai opts
+func New(w io.Writer, opts Options) (*slog.Logger, error) {
+	level, err := ParseLevel(opts.Level)
+	if err != nil {
+		return nil, err
+	}
+
+	handlerOpts := &slog.HandlerOptions{Level: level}
+	if opts.Redact {
+		handlerOpts.ReplaceAttr = redact
+	}
+
+	switch strings.ToLower(opts.Format) {
+	case FormatText, "":
+		return slog.New(slog.NewTextHandler(w, handlerOpts)), nil
+	case FormatJSON:
+		return slog.New(slog.NewJSONHandler(w, handlerOpts)), nil
+	default:
+		return nil, fmt.Errorf("unknown log format %q", opts.Format)
+	}
+}
+
+// ParseLevel menerjemahkan nama level log
+func ParseLevel(value string) (slog.Level, error) {
+	var level slog.Level
+	if value == "" {
+		return slog.LevelInfo, nil
+	}
+	if err := level.UnmarshalText([]byte(value)); err != nil {
+		return level, fmt.Errorf("unknown log level %q", value)
+	}
+	return level, nil
+}
+
+func redact(groups []string, attr slog.Attr) slog.Attr {
+	if sensitiveKeys[attr.Key] {
+		return slog.String(attr.Key, redacted)
+	}
+	return attr
+}
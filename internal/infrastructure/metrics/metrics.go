@@ -0,0 +1,117 @@
+// Package metrics berisi collector Prometheus yang di-expose lewat /metrics
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "livechat"
+
+// WebSocket
+var (
+	ActiveConnections = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "ws_active_connections",
+		Help:      "Active WebSocket connections by user type.",
+	}, []string{"user_type"})
+
+	ActiveSessions = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "ws_active_sessions",
+		Help:      "Sessions with at least one WebSocket connection on this instance.",
+	})
+
+	BroadcastFanout = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "ws_broadcast_fanout",
+		Help:      "Number of local connections a broadcast was delivered to.",
+		Buckets:   []float64{0, 1, 2, 5, 10, 20, 50, 100},
+	})
+
+	BroadcastDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "ws_broadcast_duration_seconds",
+		Help:      "Time to record and queue a broadcast to all local connections of a session.",
+		Buckets:   prometheus.ExponentialBuckets(0.0005, 2, 12),
+	})
+
+	WriteFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "ws_write_failures_total",
+		Help:      "Failed writes of data or ping frames to WebSocket connections.",
+	})
+
+	SlowConsumerDrops = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "ws_slow_consumer_drops_total",
+		Help:      "Messages dropped or connections closed because a send queue was full, by action.",
+	}, []string{"action"})
+)
+
+// Kafka
+var (
+	KafkaProduced = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "kafka_produced_total",
+		Help:      "Records written to Kafka by topic.",
+	}, []string{"topic"})
+
+	KafkaProduceErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "kafka_produce_errors_total",
+		Help:      "Failed Kafka writes by topic.",
+	}, []string{"topic"})
+
+	KafkaConsumed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "kafka_consumed_total",
+		Help:      "Records fetched from Kafka by topic.",
+	}, []string{"topic"})
+
+	KafkaConsumeErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "kafka_consume_errors_total",
+		Help:      "Kafka fetch errors and records that could not be handled, by topic.",
+	}, []string{"topic"})
+
+	KafkaConsumerLag = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "kafka_consumer_lag",
+		Help:      "Records behind the high-water mark as of the last fetched record, by topic and partition.",
+	}, []string{"topic", "partition"})
+)
+
+// Redis
+var (
+	RedisDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "redis_operation_duration_seconds",
+		Help:      "Redis command and pipeline latency by command.",
+		Buckets:   prometheus.ExponentialBuckets(0.0001, 2, 14),
+	}, []string{"command"})
+
+	RedisErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "redis_errors_total",
+		Help:      "Failed Redis commands by command.",
+	}, []string{"command"})
+)
+
+// ObserveKafkaLag mencatat lag partition dari high-water mark record terakhir
+func ObserveKafkaLag(topic string, partition int, offset, highWaterMark int64) {
+	lag := highWaterMark - offset - 1
+	if lag < 0 {
+		lag = 0
+	}
+	KafkaConsumerLag.WithLabelValues(topic, strconv.Itoa(partition)).Set(float64(lag))
+}
+
+// Handler mengembalikan handler HTTP untuk registry default
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
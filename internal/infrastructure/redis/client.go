@@ -3,12 +3,53 @@ package redis
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"time"
+
+	"livechat-ws/internal/domain"
+
+	"github.com/go-redis/redis/v8"
 )
 
-func (r *RedisClient) AddUserToSession(ctx context.Context, sessionID, userID, userType string) error {
-	key := fmt.Sprintf("session:%s:users", sessionID)
+// ErrTicketNotFound dikembalikan jika ticket tidak ada, sudah dipakai, atau expired
+var ErrTicketNotFound = errors.New("connection ticket not found")
+
+// addConnectionScript mendaftarkan connection ID beserta instance-nya ke hash milik user
+// dan menambahkan user ke hash session jika belum ada. Mengembalikan isi hash koneksi
+// user (connection ID -> instance ID) setelah ditambah.
+//
+// KEYS[1] = session users, KEYS[2] = koneksi user
+// ARGV[1] = user ID, ARGV[2] = connection ID, ARGV[3] = info user, ARGV[4] = instance ID
+var addConnectionScript = redis.NewScript(`
+redis.call('HSET', KEYS[2], ARGV[2], ARGV[4])
+redis.call('HSETNX', KEYS[1], ARGV[1], ARGV[3])
+return redis.call('HGETALL', KEYS[2])
+`)
+
+// removeConnectionScript menghapus connection ID dan baru menghapus user dari hash
+// session setelah hash koneksinya kosong. Mengembalikan sisa isi hash koneksi user.
+//
+// KEYS[1] = session users, KEYS[2] = koneksi user
+// ARGV[1] = user ID, ARGV[2] = connection ID
+var removeConnectionScript = redis.NewScript(`
+redis.call('HDEL', KEYS[2], ARGV[2])
+if redis.call('HLEN', KEYS[2]) == 0 then
+	redis.call('HDEL', KEYS[1], ARGV[1])
+end
+return redis.call('HGETALL', KEYS[2])
+`)
+
+func sessionConnectionsKey(sessionID, userID string) string {
+	return fmt.Sprintf("session:%s:user:%s:connection_instances", sessionID, userID)
+}
+
+// AddUserToSession mencatat satu koneksi user di session dan mengembalikan jumlah
+// koneksi user tersebut (lintas tab dan instance). Koneksi milik instance yang sudah
+// hilang dari registry tidak ikut dihitung, tetapi tidak dihapus.
+func (r *RedisClient) AddUserToSession(ctx context.Context, sessionID, userID, userType, connectionID, instanceID string) (int64, error) {
+	usersKey := fmt.Sprintf("session:%s:users", sessionID)
+	connectionsKey := sessionConnectionsKey(sessionID, userID)
 	userInfo := map[string]interface{}{
 		"user_id":   userID,
 		"user_type": userType,
@@ -17,24 +58,69 @@ func (r *RedisClient) AddUserToSession(ctx context.Context, sessionID, userID, u
 
 	userJSON, err := json.Marshal(userInfo)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	return r.client.HSet(ctx, key, userID, userJSON).Err()
+	entries, err := addConnectionScript.Run(ctx, r.client, []string{usersKey, connectionsKey},
+		userID, connectionID, userJSON, instanceID).StringSlice()
+	if err != nil {
+		return 0, err
+	}
+	return r.countLiveConnections(ctx, instanceID, pairs(entries))
 }
 
-func (r *RedisClient) RemoveUserFromSession(ctx context.Context, sessionID, userID, userType string) error {
-	key := fmt.Sprintf("session:%s:users", sessionID)
-	return r.client.HDel(ctx, key, userID).Err()
+// RemoveUserFromSession menghapus satu koneksi user dan mengembalikan sisa koneksinya
+// di instance yang masih hidup. User dihapus dari hash session setelah hash koneksinya
+// kosong; selama masih ada koneksi milik instance mati, user hanya disaring saat dibaca.
+func (r *RedisClient) RemoveUserFromSession(ctx context.Context, sessionID, userID, userType, connectionID, instanceID string) (int64, error) {
+	usersKey := fmt.Sprintf("session:%s:users", sessionID)
+	connectionsKey := sessionConnectionsKey(sessionID, userID)
+	entries, err := removeConnectionScript.Run(ctx, r.client, []string{usersKey, connectionsKey},
+		userID, connectionID).StringSlice()
+	if err != nil {
+		return 0, err
+	}
+	return r.countLiveConnections(ctx, instanceID, pairs(entries))
 }
 
-func (r *RedisClient) GetSessionUsers(ctx context.Context, sessionID string) (map[string]interface{}, error) {
-	key := fmt.Sprintf("session:%s:users", sessionID)
-	users, err := r.client.HGetAll(ctx, key).Result()
+// GetSessionUsers mengembalikan user yang terhubung ke session. User yang hanya punya
+// koneksi di instance yang sudah mati (crash tanpa cleanup) tidak ikut dikembalikan.
+// ownInstance selalu dianggap hidup.
+func (r *RedisClient) GetSessionUsers(ctx context.Context, sessionID, ownInstance string) (map[string]interface{}, error) {
+	users, err := r.client.HGetAll(ctx, fmt.Sprintf("session:%s:users", sessionID)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	connections := make(map[string]*redis.StringStringMapCmd, len(users))
+	if len(users) > 0 {
+		pipe := r.client.Pipeline()
+		for userID := range users {
+			connections[userID] = pipe.HGetAll(ctx, sessionConnectionsKey(sessionID, userID))
+		}
+		if _, err := pipe.Exec(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	var instanceIDs []string
+	for _, cmd := range connections {
+		for _, instanceID := range cmd.Val() {
+			instanceIDs = append(instanceIDs, instanceID)
+		}
+	}
+	live, err := r.liveInstances(ctx, ownInstance, instanceIDs)
 	if err != nil {
 		return nil, err
 	}
 
+	// User tanpa hash koneksi (ditulis versi lama) tetap dianggap terhubung
+	for userID, cmd := range connections {
+		if len(cmd.Val()) > 0 && countLive(cmd.Val(), live) == 0 {
+			delete(users, userID)
+		}
+	}
+
 	result := make(map[string]interface{})
 	customerCount := 0
 	agentCount := 0
@@ -64,6 +150,63 @@ func (r *RedisClient) GetSessionUsers(ctx context.Context, sessionID string) (ma
 	}, nil
 }
 
+// countLiveConnections menghitung koneksi di hash koneksi user yang instance-nya masih hidup
+func (r *RedisClient) countLiveConnections(ctx context.Context, ownInstance string, connections map[string]string) (int64, error) {
+	instanceIDs := make([]string, 0, len(connections))
+	for _, instanceID := range connections {
+		instanceIDs = append(instanceIDs, instanceID)
+	}
+	live, err := r.liveInstances(ctx, ownInstance, instanceIDs)
+	if err != nil {
+		return 0, err
+	}
+	return int64(countLive(connections, live)), nil
+}
+
+// liveInstances memeriksa key registry instanceIDs. ownInstance selalu dianggap hidup
+// supaya heartbeat yang terlambat (Redis lambat atau heartbeat pertama belum tertulis)
+// tidak menyembunyikan koneksi instance pemanggil sendiri.
+func (r *RedisClient) liveInstances(ctx context.Context, ownInstance string, instanceIDs []string) (map[string]bool, error) {
+	live := map[string]bool{ownInstance: true}
+	checks := make(map[string]*redis.IntCmd)
+	pipe := r.client.Pipeline()
+	for _, instanceID := range instanceIDs {
+		if live[instanceID] || checks[instanceID] != nil {
+			continue
+		}
+		checks[instanceID] = pipe.Exists(ctx, instanceKey(instanceID))
+	}
+	if len(checks) == 0 {
+		return live, nil
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+	for instanceID, cmd := range checks {
+		live[instanceID] = cmd.Val() > 0
+	}
+	return live, nil
+}
+
+func countLive(connections map[string]string, live map[string]bool) int {
+	count := 0
+	for _, instanceID := range connections {
+		if live[instanceID] {
+			count++
+		}
+	}
+	return count
+}
+
+// pairs mengubah balasan HGETALL dari script menjadi map
+func pairs(entries []string) map[string]string {
+	result := make(map[string]string, len(entries)/2)
+	for i := 0; i+1 < len(entries); i += 2 {
+		result[entries[i]] = entries[i+1]
+	}
+	return result
+}
+
 func (r *RedisClient) SetUserTyping(ctx context.Context, sessionID, userID string, isTyping bool) error {
 	key := fmt.Sprintf("session:%s:typing:%s", sessionID, userID)
 	if isTyping {
@@ -93,6 +236,181 @@ func (r *RedisClient) GetTypingUsers(ctx context.Context, sessionID string) ([]s
 	return typingUsers, nil
 }
 
+// CreateConnectionTicket menyimpan ticket sekali pakai untuk upgrade WebSocket
+func (r *RedisClient) CreateConnectionTicket(ctx context.Context, ticket string, info domain.ConnectionTicket, ttl time.Duration) error {
+	key := fmt.Sprintf("ws:ticket:%s", ticket)
+	ticketJSON, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+
+	created, err := r.client.SetNX(ctx, key, ticketJSON, ttl).Result()
+	if err != nil {
+		return err
+	}
+	if !created {
+		return fmt.Errorf("connection ticket already exists")
+	}
+	return nil
+}
+
+// ConsumeConnectionTicket mengambil dan menghapus ticket secara atomik (GETDEL),
+// sehingga ticket yang sama tidak bisa dipakai dua kali
+func (r *RedisClient) ConsumeConnectionTicket(ctx context.Context, ticket string) (*domain.ConnectionTicket, error) {
+	key := fmt.Sprintf("ws:ticket:%s", ticket)
+	ticketJSON, err := r.client.GetDel(ctx, key).Result()
+	if err == redis.Nil {
+		return nil, ErrTicketNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var info domain.ConnectionTicket
+	if err := json.Unmarshal([]byte(ticketJSON), &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+func clientMessageKey(sessionID, userID, clientMessageID string) string {
+	return fmt.Sprintf("session:%s:user:%s:client_message:%s", sessionID, userID, clientMessageID)
+}
+
+// ReserveClientMessage mencatat client_message_id milik user dengan SETNX, biasanya dengan
+// status pending dan ttl pendek. Jika ID tersebut sudah pernah dipakai user yang sama di
+// session yang sama, record lama dikembalikan dan reserved bernilai false.
+func (r *RedisClient) ReserveClientMessage(ctx context.Context, sessionID, userID, clientMessageID string, record domain.ClientMessageRecord, ttl time.Duration) (*domain.ClientMessageRecord, bool, error) {
+	key := clientMessageKey(sessionID, userID, clientMessageID)
+	recordJSON, err := json.Marshal(record)
+	if err != nil {
+		return nil, false, err
+	}
+
+	reserved, err := r.client.SetNX(ctx, key, recordJSON, ttl).Result()
+	if err != nil {
+		return nil, false, err
+	}
+	if reserved {
+		return &record, true, nil
+	}
+
+	existingJSON, err := r.client.Get(ctx, key).Result()
+	if err != nil {
+		return nil, false, err
+	}
+
+	var existing domain.ClientMessageRecord
+	if err := json.Unmarshal([]byte(existingJSON), &existing); err != nil {
+		return nil, false, err
+	}
+	return &existing, false, nil
+}
+
+// ConfirmClientMessage mengganti reservasi pending dengan record final setelah publish
+// berhasil, sehingga retry berikutnya mendapat ack yang sama selama ttl
+func (r *RedisClient) ConfirmClientMessage(ctx context.Context, sessionID, userID, clientMessageID string, record domain.ClientMessageRecord, ttl time.Duration) error {
+	recordJSON, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return r.client.Set(ctx, clientMessageKey(sessionID, userID, clientMessageID), recordJSON, ttl).Err()
+}
+
+// ReleaseClientMessage menghapus reservasi client_message_id, misalnya saat publish gagal
+// sehingga retry dari client bisa diproses ulang
+func (r *RedisClient) ReleaseClientMessage(ctx context.Context, sessionID, userID, clientMessageID string) error {
+	return r.client.Del(ctx, clientMessageKey(sessionID, userID, clientMessageID)).Err()
+}
+
+// appendEventScript mengalokasikan nomor urut per session dan mencatat event ke Redis
+// Stream session dengan stream ID "<seq>-1", dalam satu operasi atomik. Jika event ID
+// diberikan, event yang sama dari instance lain mendapat seq yang sama dan tidak dicatat ulang.
+//
+// KEYS[1] = seq counter, KEYS[2] = event stream, KEYS[3] = event ID -> seq (opsional)
+// ARGV[1] = payload, ARGV[2] = max stream length, ARGV[3] = retention (detik), ARGV[4] = event ID TTL (detik)
+var appendEventScript = redis.NewScript(`
+if #KEYS > 2 then
+	local existing = redis.call('GET', KEYS[3])
+	if existing then
+		return tonumber(existing)
+	end
+end
+local seq = redis.call('INCR', KEYS[1])
+redis.call('XADD', KEYS[2], 'MAXLEN', '~', ARGV[2], seq .. '-1', 'data', ARGV[1])
+redis.call('EXPIRE', KEYS[1], ARGV[3])
+redis.call('EXPIRE', KEYS[2], ARGV[3])
+if #KEYS > 2 then
+	redis.call('SET', KEYS[3], seq, 'EX', ARGV[4])
+end
+return seq
+`)
+
+// AppendSessionEvent mengalokasikan seq untuk event dan menyimpannya di event log session.
+// Mapping event ID -> seq disimpan selama dedupTTL; instance yang memproses event yang
+// sama setelah itu akan mencatatnya ulang dengan seq baru.
+func (r *RedisClient) AppendSessionEvent(ctx context.Context, sessionID, eventID string, payload []byte, maxLen int64, retention, dedupTTL time.Duration) (int64, error) {
+	keys := []string{
+		fmt.Sprintf("session:%s:seq", sessionID),
+		fmt.Sprintf("session:%s:events", sessionID),
+	}
+	if eventID != "" {
+		keys = append(keys, fmt.Sprintf("session:%s:event:%s:seq", sessionID, eventID))
+	}
+	return appendEventScript.Run(ctx, r.client, keys, payload, maxLen,
+		int(retention.Seconds()), int(dedupTTL.Seconds())).Int64()
+}
+
+// GetSessionSeq mengembalikan seq terakhir yang sudah dialokasikan untuk session
+func (r *RedisClient) GetSessionSeq(ctx context.Context, sessionID string) (int64, error) {
+	key := fmt.Sprintf("session:%s:seq", sessionID)
+	seq, err := r.client.Get(ctx, key).Int64()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	return seq, err
+}
+
+// GetSessionEventsSince membaca maksimal limit event dengan seq > afterSeq secara berurutan.
+// complete bernilai false jika sebagian event sudah terhapus dari log, sehingga client harus
+// melakukan resync penuh. Jika hasilnya berisi limit event, halaman berikutnya dibaca dengan
+// afterSeq = seq terakhir.
+func (r *RedisClient) GetSessionEventsSince(ctx context.Context, sessionID string, afterSeq, limit int64) ([]domain.SessionEvent, bool, error) {
+	currentSeq, err := r.GetSessionSeq(ctx, sessionID)
+	if err != nil {
+		return nil, false, err
+	}
+	if afterSeq > currentSeq {
+		// Seq lebih besar dari yang pernah dialokasikan, log sudah expired/reset
+		return nil, false, nil
+	}
+	if afterSeq == currentSeq {
+		return nil, true, nil
+	}
+
+	key := fmt.Sprintf("session:%s:events", sessionID)
+	entries, err := r.client.XRangeN(ctx, key, fmt.Sprintf("%d-0", afterSeq+1), "+", limit).Result()
+	if err != nil {
+		return nil, false, err
+	}
+
+	events := make([]domain.SessionEvent, 0, len(entries))
+	for _, entry := range entries {
+		var seq int64
+		if _, err := fmt.Sscanf(entry.ID, "%d-", &seq); err != nil {
+			return nil, false, fmt.Errorf("invalid event stream ID %s: %w", entry.ID, err)
+		}
+		data, _ := entry.Values["data"].(string)
+		events = append(events, domain.SessionEvent{Seq: seq, Payload: []byte(data)})
+	}
+
+	if len(events) == 0 || events[0].Seq != afterSeq+1 {
+		return nil, false, nil
+	}
+
+	return events, true, nil
+}
+
 func (r *RedisClient) Ping(ctx context.Context) error {
 	return r.client.Ping(ctx).Err()
 }
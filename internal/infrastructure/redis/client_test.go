@@ -0,0 +1,168 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"livechat-ws/internal/domain"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func newTestClient(t *testing.T) (*RedisClient, *miniredis.Miniredis) {
+	t.Helper()
+
+	server := miniredis.RunT(t)
+	client := NewRedisClient(server.Host(), server.Port(), "")
+	t.Cleanup(func() { client.Close() })
+	return client, server
+}
+
+func registerTestInstance(t *testing.T, client *RedisClient, instanceID string) {
+	t.Helper()
+
+	info := domain.InstanceInfo{InstanceID: instanceID, LastHeartbeat: time.Now()}
+	if err := client.RegisterInstance(context.Background(), info, 30*time.Second); err != nil {
+		t.Fatalf("register %s: %v", instanceID, err)
+	}
+}
+
+func sessionUserIDs(t *testing.T, client *RedisClient, sessionID, ownInstance string) map[string]interface{} {
+	t.Helper()
+
+	status, err := client.GetSessionUsers(context.Background(), sessionID, ownInstance)
+	if err != nil {
+		t.Fatalf("get session users: %v", err)
+	}
+	return status["users"].(map[string]interface{})
+}
+
+func TestPresenceCountsConnectionsAcrossInstances(t *testing.T) {
+	client, _ := newTestClient(t)
+	ctx := context.Background()
+	registerTestInstance(t, client, "ws-1")
+	registerTestInstance(t, client, "ws-2")
+
+	if count, err := client.AddUserToSession(ctx, "s1", "u1", "customer", "c1", "ws-1"); err != nil || count != 1 {
+		t.Fatalf("first connection: count=%d err=%v", count, err)
+	}
+	if count, err := client.AddUserToSession(ctx, "s1", "u1", "customer", "c2", "ws-2"); err != nil || count != 2 {
+		t.Fatalf("second connection: count=%d err=%v", count, err)
+	}
+
+	if remaining, err := client.RemoveUserFromSession(ctx, "s1", "u1", "customer", "c1", "ws-1"); err != nil || remaining != 1 {
+		t.Fatalf("remove first: remaining=%d err=%v", remaining, err)
+	}
+	if _, ok := sessionUserIDs(t, client, "s1", "ws-2")["u1"]; !ok {
+		t.Fatal("user removed while it still has a connection")
+	}
+
+	if remaining, err := client.RemoveUserFromSession(ctx, "s1", "u1", "customer", "c2", "ws-2"); err != nil || remaining != 0 {
+		t.Fatalf("remove second: remaining=%d err=%v", remaining, err)
+	}
+	if _, ok := sessionUserIDs(t, client, "s1", "ws-2")["u1"]; ok {
+		t.Fatal("user still in session after last connection closed")
+	}
+}
+
+func TestPresencePrunesConnectionsOfCrashedInstance(t *testing.T) {
+	client, server := newTestClient(t)
+	ctx := context.Background()
+	registerTestInstance(t, client, "ws-1")
+	registerTestInstance(t, client, "ws-2")
+
+	// ws-1 crash tanpa RemoveUserFromSession, heartbeat-nya berhenti sampai key instance expire
+	if _, err := client.AddUserToSession(ctx, "s1", "u1", "customer", "c1", "ws-1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.AddUserToSession(ctx, "s1", "agent", "agent", "c2", "ws-1"); err != nil {
+		t.Fatal(err)
+	}
+	server.FastForward(31 * time.Second)
+	registerTestInstance(t, client, "ws-2")
+
+	users := sessionUserIDs(t, client, "s1", "ws-2")
+	if len(users) != 0 {
+		t.Fatalf("users of crashed instance still online: %v", users)
+	}
+
+	// Reconnect ke instance lain dihitung sebagai koneksi pertama, jadi user_connected
+	// dan user_disconnected tetap terkirim
+	count, err := client.AddUserToSession(ctx, "s1", "u1", "customer", "c3", "ws-2")
+	if err != nil || count != 1 {
+		t.Fatalf("reconnect: count=%d err=%v", count, err)
+	}
+	remaining, err := client.RemoveUserFromSession(ctx, "s1", "u1", "customer", "c3", "ws-2")
+	if err != nil || remaining != 0 {
+		t.Fatalf("disconnect: remaining=%d err=%v", remaining, err)
+	}
+}
+
+// TestPresenceKeepsConnectionsOfLateInstance memastikan instance yang heartbeat-nya
+// terlambat tidak kehilangan koneksinya: entry hanya disaring saat dibaca, tidak dihapus
+func TestPresenceKeepsConnectionsOfLateInstance(t *testing.T) {
+	client, server := newTestClient(t)
+	ctx := context.Background()
+	registerTestInstance(t, client, "ws-1")
+	registerTestInstance(t, client, "ws-2")
+
+	if _, err := client.AddUserToSession(ctx, "s1", "u1", "customer", "c1", "ws-1"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Key instance ws-1 expire, misalnya karena Redis lambat lebih lama dari INSTANCE_TTL
+	server.FastForward(31 * time.Second)
+	registerTestInstance(t, client, "ws-2")
+
+	if _, ok := sessionUserIDs(t, client, "s1", "ws-1")["u1"]; !ok {
+		t.Fatal("instance hides its own live connection while its heartbeat is late")
+	}
+	if _, ok := sessionUserIDs(t, client, "s1", "ws-2")["u1"]; ok {
+		t.Fatal("connection of unregistered instance visible to other instances")
+	}
+
+	// Koneksi kedua user di ws-1 tetap dihitung bersama koneksi pertamanya
+	if count, err := client.AddUserToSession(ctx, "s1", "u1", "customer", "c2", "ws-1"); err != nil || count != 2 {
+		t.Fatalf("second connection on late instance: count=%d err=%v", count, err)
+	}
+
+	// Setelah heartbeat berikutnya, instance lain melihat user lagi
+	registerTestInstance(t, client, "ws-1")
+	if _, ok := sessionUserIDs(t, client, "s1", "ws-2")["u1"]; !ok {
+		t.Fatal("connection lost after instance re-registered")
+	}
+}
+
+func TestConnectionTicketIsConsumedOnce(t *testing.T) {
+	client, server := newTestClient(t)
+	ctx := context.Background()
+	info := domain.ConnectionTicket{UserID: "u1", UserType: "customer", SessionID: "s1", IssuedAt: time.Now()}
+
+	if err := client.CreateConnectionTicket(ctx, "t1", info, 30*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.CreateConnectionTicket(ctx, "t1", info, 30*time.Second); err == nil {
+		t.Fatal("existing ticket overwritten")
+	}
+
+	got, err := client.ConsumeConnectionTicket(ctx, "t1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.UserID != "u1" || got.UserType != "customer" || got.SessionID != "s1" {
+		t.Fatalf("got ticket %+v, want %+v", got, info)
+	}
+	if _, err := client.ConsumeConnectionTicket(ctx, "t1"); !errors.Is(err, ErrTicketNotFound) {
+		t.Fatalf("second consume: got %v, want ErrTicketNotFound", err)
+	}
+
+	if err := client.CreateConnectionTicket(ctx, "t2", info, 30*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	server.FastForward(31 * time.Second)
+	if _, err := client.ConsumeConnectionTicket(ctx, "t2"); !errors.Is(err, ErrTicketNotFound) {
+		t.Fatalf("expired ticket: got %v, want ErrTicketNotFound", err)
+	}
+}
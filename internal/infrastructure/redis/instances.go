@@ -0,0 +1,88 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"livechat-ws/internal/domain"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// instancesKey adalah sorted set ID instance dengan score waktu heartbeat terakhir (unix ms)
+const instancesKey = "instances"
+
+// instanceKeyPrefix juga dipakai presence untuk memeriksa apakah instance masih hidup
+const instanceKeyPrefix = "instance:"
+
+func instanceKey(instanceID string) string {
+	return instanceKeyPrefix + instanceID
+}
+
+// RegisterInstance menyimpan info instance dengan TTL. Instance yang mati tanpa
+// DeregisterInstance otomatis hilang setelah ttl karena heartbeat-nya berhenti.
+func (r *RedisClient) RegisterInstance(ctx context.Context, info domain.InstanceInfo, ttl time.Duration) error {
+	infoJSON, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+
+	pipe := r.client.TxPipeline()
+	pipe.Set(ctx, instanceKey(info.InstanceID), infoJSON, ttl)
+	pipe.ZAdd(ctx, instancesKey, &redis.Z{Score: float64(info.LastHeartbeat.UnixMilli()), Member: info.InstanceID})
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// DeregisterInstance menghapus instance dari registry saat shutdown
+func (r *RedisClient) DeregisterInstance(ctx context.Context, instanceID string) error {
+	pipe := r.client.TxPipeline()
+	pipe.Del(ctx, instanceKey(instanceID))
+	pipe.ZRem(ctx, instancesKey, instanceID)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// ListInstances mengembalikan instance yang heartbeat-nya masih dalam ttl. Entry yang
+// lebih lama dibersihkan dari sorted set sekalian.
+func (r *RedisClient) ListInstances(ctx context.Context, ttl time.Duration) ([]domain.InstanceInfo, error) {
+	cutoff := strconv.FormatInt(time.Now().Add(-ttl).UnixMilli(), 10)
+	if err := r.client.ZRemRangeByScore(ctx, instancesKey, "-inf", "("+cutoff).Err(); err != nil {
+		return nil, err
+	}
+
+	ids, err := r.client.ZRange(ctx, instancesKey, 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return []domain.InstanceInfo{}, nil
+	}
+
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = instanceKey(id)
+	}
+	values, err := r.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	instances := make([]domain.InstanceInfo, 0, len(values))
+	for i, value := range values {
+		data, ok := value.(string)
+		if !ok {
+			// Key sudah expire tapi score belum lewat cutoff
+			continue
+		}
+		var info domain.InstanceInfo
+		if err := json.Unmarshal([]byte(data), &info); err != nil {
+			return nil, fmt.Errorf("invalid instance info %s: %w", ids[i], err)
+		}
+		instances = append(instances, info)
+	}
+	return instances, nil
+}
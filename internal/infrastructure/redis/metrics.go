@@ -0,0 +1,47 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"livechat-ws/internal/infrastructure/metrics"
+
+	"github.com/go-redis/redis/v8"
+)
+
+type startTimeKey struct{}
+
+// metricsHook mencatat latency dan error setiap command Redis. Pipeline dan transaksi
+// dicatat sebagai satu operasi "pipeline".
+type metricsHook struct{}
+
+func (metricsHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	return context.WithValue(ctx, startTimeKey{}, time.Now()), nil
+}
+
+func (metricsHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	observe(ctx, cmd.Name(), cmd)
+	return nil
+}
+
+func (metricsHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	return context.WithValue(ctx, startTimeKey{}, time.Now()), nil
+}
+
+func (metricsHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	observe(ctx, "pipeline", cmds...)
+	return nil
+}
+
+func observe(ctx context.Context, command string, cmds ...redis.Cmder) {
+	if start, ok := ctx.Value(startTimeKey{}).(time.Time); ok {
+		metrics.RedisDuration.WithLabelValues(command).Observe(time.Since(start).Seconds())
+	}
+	for _, cmd := range cmds {
+		// redis.Nil berarti key tidak ada, bukan kegagalan
+		if err := cmd.Err(); err != nil && !errors.Is(err, redis.Nil) {
+			metrics.RedisErrors.WithLabelValues(command).Inc()
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"livechat-ws/internal/domain"
+	"livechat-ws/internal/infrastructure/broker"
+	"livechat-ws/internal/infrastructure/tracing"
+
+	"github.com/go-redis/redis/v8"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// pubSubChannelPrefix membedakan channel event livechat dari key Redis lain
+const pubSubChannelPrefix = "livechat:"
+
+// pubSubMessage membungkus event karena Redis Pub/Sub tidak punya header/key seperti Kafka
+type pubSubMessage struct {
+	Origin string          `json:"origin"`
+	Key    string          `json:"key"`
+	Value  json.RawMessage `json:"value"`
+	// Trace berisi W3C trace context (traceparent, tracestate, baggage)
+	Trace map[string]string `json:"trace,omitempty"`
+}
+
+// RedisBroker mengimplementasikan broker.Broker dengan Redis Pub/Sub. Tidak ada
+// persistensi: event yang di-publish saat instance tidak subscribe akan hilang.
+type RedisBroker struct {
+	client     *redis.Client
+	instanceID string
+	codec      broker.Codec
+	subs       []*redis.PubSub
+	mutex      sync.Mutex
+	// running adalah jumlah goroutine subscriber yang masih berjalan
+	running atomic.Int32
+	logger  *slog.Logger
+}
+
+// NewRedisBroker membuat broker Redis Pub/Sub. CloudEvents selalu dikirim dalam mode
+// structured karena Pub/Sub tidak punya header.
+func NewRedisBroker(r *RedisClient, instanceID string, codec broker.Codec, logger *slog.Logger) *RedisBroker {
+	return &RedisBroker{
+		client:     r.client,
+		instanceID: instanceID,
+		codec:      codec,
+		logger:     logger,
+	}
+}
+
+func (b *RedisBroker) Publish(ctx context.Context, event *domain.EventEnvelope) error {
+	value, err := b.codec.Encode(broker.WithOrigin(event, b.instanceID))
+	if err != nil {
+		return err
+	}
+
+	traceContext := propagation.MapCarrier{}
+	tracing.Inject(ctx, traceContext)
+
+	data, err := json.Marshal(pubSubMessage{
+		Origin: b.instanceID,
+		Key:    event.SessionID,
+		Value:  value,
+		Trace:  traceContext,
+	})
+	if err != nil {
+		return err
+	}
+
+	topic := broker.TopicForEvent(event)
+	if err := b.client.Publish(ctx, pubSubChannelPrefix+topic, data).Err(); err != nil {
+		b.logger.Error("Failed to publish message to Redis", "channel", topic, "session_id", event.SessionID, "event_id", event.ID, "error", err)
+		return err
+	}
+
+	b.logger.Debug("Message sent to Redis", "channel", topic, "session_id", event.SessionID, "event_id", event.ID)
+	return nil
+}
+
+func (b *RedisBroker) Subscribe(ctx context.Context, topics []string, handler broker.Handler) error {
+	channels := make([]string, len(topics))
+	for i, topic := range topics {
+		channels[i] = pubSubChannelPrefix + topic
+	}
+
+	sub := b.client.Subscribe(ctx, channels...)
+	// Pastikan subscription aktif sebelum return supaya event berikutnya tidak terlewat
+	if _, err := sub.Receive(ctx); err != nil {
+		sub.Close()
+		return err
+	}
+
+	b.mutex.Lock()
+	b.subs = append(b.subs, sub)
+	b.mutex.Unlock()
+
+	b.running.Add(1)
+	go func() {
+		defer b.running.Add(-1)
+		defer sub.Close()
+
+		ch := sub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				b.logger.Info("Redis subscriber stopping")
+				return
+			case msg, ok := <-ch:
+				if !ok {
+					return
+				}
+				b.handleMessage(ctx, handler, msg)
+			}
+		}
+	}()
+
+	return nil
+}
+
+func (b *RedisBroker) handleMessage(ctx context.Context, handler broker.Handler, msg *redis.Message) {
+	// Recovery dari panic untuk mencegah subscriber berhenti
+	defer func() {
+		if r := recover(); r != nil {
+			b.logger.Error("Recovered from panic in Redis subscriber", "channel", msg.Channel, "panic", r)
+		}
+	}()
+
+	topic := strings.TrimPrefix(msg.Channel, pubSubChannelPrefix)
+
+	var message pubSubMessage
+	if err := json.Unmarshal([]byte(msg.Payload), &message); err != nil {
+		b.logger.Error("Error unmarshaling Redis event", "channel", msg.Channel, "error", err)
+		return
+	}
+
+	event, err := broker.Decode(topic, message.Value)
+	if err != nil {
+		b.logger.Error("Error decoding Redis event", "channel", msg.Channel, "error", err)
+		return
+	}
+	if event.Metadata[broker.MetadataOriginInstance] == "" && message.Origin != "" {
+		event = broker.WithOrigin(event, message.Origin)
+	}
+
+	if broker.IsOwnEcho(event, b.instanceID) {
+		return
+	}
+
+	ctx, span := tracing.Tracer().Start(tracing.Extract(ctx, propagation.MapCarrier(message.Trace)), "redis.consume "+topic,
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			tracing.AttrSessionID.String(event.SessionID),
+			tracing.AttrEventID.String(event.ID),
+			tracing.AttrEventType.String(event.Type),
+		))
+	defer span.End()
+
+	if err := handler.HandleEvent(ctx, event); err != nil {
+		tracing.RecordError(span, err)
+		b.logger.Error("Error handling Redis event", "channel", msg.Channel, "event_type", event.Type, "event_id", event.ID, "session_id", event.SessionID, "error", err)
+	}
+}
+
+// Ping memastikan Redis bisa dijangkau
+func (b *RedisBroker) Ping(ctx context.Context) error {
+	return b.client.Ping(ctx).Err()
+}
+
+// SubscriberHealth mengembalikan error jika belum subscribe atau ada goroutine subscriber yang berhenti
+func (b *RedisBroker) SubscriberHealth() error {
+	b.mutex.Lock()
+	subscribed := len(b.subs)
+	b.mutex.Unlock()
+
+	if subscribed == 0 {
+		return errors.New("not subscribed")
+	}
+	if running := int(b.running.Load()); running < subscribed {
+		return fmt.Errorf("%d of %d subscribers stopped", subscribed-running, subscribed)
+	}
+	return nil
+}
+
+// Close menghentikan semua subscription. Client Redis sendiri ditutup oleh RedisClient.
+func (b *RedisBroker) Close() error {
+	b.mutex.Lock()
+	subs := b.subs
+	b.subs = nil
+	b.mutex.Unlock()
+
+	var errs []error
+	for _, sub := range subs {
+		if err := sub.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
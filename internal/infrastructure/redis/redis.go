@@ -17,5 +17,7 @@ func NewRedisClient(host, port, password string) *RedisClient {
 		Password: password,
 		DB:       0,
 	})
+	client.AddHook(metricsHook{})
+	client.AddHook(tracingHook{})
 	return &RedisClient{client: client}
 }
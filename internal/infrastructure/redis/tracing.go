@@ -0,0 +1,66 @@
+package redis
+
+import (
+	"context"
+	"errors"
+
+	"livechat-ws/internal/infrastructure/tracing"
+
+	"github.com/go-redis/redis/v8"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracingHook membuat span untuk command Redis. Span hanya dibuat di dalam trace yang
+// sudah berjalan (misalnya broadcast dari consumer), supaya command rutin seperti
+// presence tidak masing-masing menjadi trace baru.
+type tracingHook struct{}
+
+func (tracingHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	return startSpan(ctx, cmd.Name()), nil
+}
+
+func (tracingHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	endSpan(ctx, cmd)
+	return nil
+}
+
+func (tracingHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	ctx = startSpan(ctx, "pipeline")
+	trace.SpanFromContext(ctx).SetAttributes(attribute.Int("db.redis.pipeline_length", len(cmds)))
+	return ctx, nil
+}
+
+func (tracingHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	endSpan(ctx, cmds...)
+	return nil
+}
+
+// redisSpanKey menyimpan span milik hook supaya AfterProcess tidak mengakhiri span parent
+type redisSpanKey struct{}
+
+func startSpan(ctx context.Context, operation string) context.Context {
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		return ctx
+	}
+	ctx, span := tracing.Tracer().Start(ctx, "redis "+operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.DBSystemRedis, semconv.DBOperation(operation)))
+	return context.WithValue(ctx, redisSpanKey{}, span)
+}
+
+func endSpan(ctx context.Context, cmds ...redis.Cmder) {
+	span, ok := ctx.Value(redisSpanKey{}).(trace.Span)
+	if !ok {
+		return
+	}
+	for _, cmd := range cmds {
+		// redis.Nil berarti key tidak ada, bukan kegagalan
+		if err := cmd.Err(); err != nil && !errors.Is(err, redis.Nil) {
+			tracing.RecordError(span, err)
+			break
+		}
+	}
+	span.End()
+}
@@ -0,0 +1,125 @@
+// Package tracing menyiapkan OpenTelemetry tracing dan propagasi W3C trace context
+package tracing
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// Exporter span
+const (
+	// ExporterNone memakai tracer no-op: span tidak dibuat, tetapi trace context yang
+	// masuk tetap diteruskan ke Kafka dan Redis
+	ExporterNone = "none"
+	// ExporterOTLP mengirim span lewat OTLP/HTTP
+	ExporterOTLP = "otlp"
+)
+
+const tracerName = "livechat-ws"
+
+// Attribute span khusus aplikasi
+const (
+	AttrSessionID    = attribute.Key("livechat.session_id")
+	AttrEventID      = attribute.Key("livechat.event_id")
+	AttrEventType    = attribute.Key("livechat.event_type")
+	AttrConnectionID = attribute.Key("livechat.connection_id")
+	AttrFanout       = attribute.Key("livechat.broadcast.fanout")
+	AttrSeq          = attribute.Key("livechat.seq")
+)
+
+// Options mengatur tracer provider yang dipasang Setup
+type Options struct {
+	Exporter string
+	// OTLPEndpoint adalah host:port collector, misalnya localhost:4318
+	OTLPEndpoint string
+	OTLPInsecure bool
+	ServiceName  string
+	InstanceID   string
+	// SampleRatio berlaku untuk trace baru, trace dari upstream mengikuti keputusan sampling-nya
+	SampleRatio float64
+}
+
+// Setup memasang tracer provider dan propagator W3C (traceparent, baggage) global.
+// Fungsi yang dikembalikan mengirim span yang tersisa saat shutdown.
+func Setup(ctx context.Context, opts Options) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{}, propagation.Baggage{}))
+
+	switch opts.Exporter {
+	case ExporterNone, "":
+		otel.SetTracerProvider(noop.NewTracerProvider())
+		return func(context.Context) error { return nil }, nil
+	case ExporterOTLP:
+	default:
+		return nil, fmt.Errorf("unknown tracing exporter %q", opts.Exporter)
+	}
+
+	clientOpts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(opts.OTLPEndpoint)}
+	if opts.OTLPInsecure {
+		clientOpts = append(clientOpts, otlptracehttp.WithInsecure())
+	}
+	exporter, err := otlptracehttp.New(ctx, clientOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP exporter: %w", err)
+	}
+
+	provider, err := newProvider(opts, sdktrace.WithBatcher(exporter))
+	if err != nil {
+		return nil, err
+	}
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown, nil
+}
+
+// newProvider membuat tracer provider dengan resource dan sampler aplikasi. Span
+// dikirim lewat processor, test memakai exporter in-memory sebagai pengganti OTLP.
+func newProvider(opts Options, processor sdktrace.TracerProviderOption) (*sdktrace.TracerProvider, error) {
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(
+		attribute.String("service.name", opts.ServiceName),
+		attribute.String("service.instance.id", opts.InstanceID),
+	))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create tracing resource: %w", err)
+	}
+
+	return sdktrace.NewTracerProvider(
+		processor,
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(opts.SampleRatio))),
+	), nil
+}
+
+// Tracer mengembalikan tracer aplikasi dari provider global
+func Tracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
+
+// Inject menulis trace context ctx ke carrier, misalnya header Kafka
+func Inject(ctx context.Context, carrier propagation.TextMapCarrier) {
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+}
+
+// Extract mengembalikan ctx dengan trace context dari carrier
+func Extract(ctx context.Context, carrier propagation.TextMapCarrier) context.Context {
+	return otel.GetTextMapPropagator().Extract(ctx, carrier)
+}
+
+// RecordError menandai span gagal. err nil diabaikan.
+func RecordError(span trace.Span, err error) {
+	if err == nil {
+		return
+	}
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
@@ -0,0 +1,151 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// resetTracing mengembalikan provider global ke no-op setelah test selesai
+func resetTracing(t *testing.T) {
+	t.Helper()
+	t.Cleanup(func() {
+		if _, err := Setup(context.Background(), Options{Exporter: ExporterNone}); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestSetup(t *testing.T) {
+	// Pengganti collector OTLP/HTTP yang hanya menghitung request export
+	var exports atomic.Int32
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost && r.URL.Path == "/v1/traces" {
+			exports.Add(1)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer collector.Close()
+	endpoint := strings.TrimPrefix(collector.URL, "http://")
+
+	tests := []struct {
+		name          string
+		opts          Options
+		wantErr       string
+		wantRecording bool
+		wantExports   bool
+	}{
+		{name: "empty exporter", opts: Options{}},
+		{name: "none", opts: Options{Exporter: ExporterNone}},
+		{name: "otlp", opts: Options{Exporter: ExporterOTLP, OTLPEndpoint: endpoint, OTLPInsecure: true, SampleRatio: 1},
+			wantRecording: true, wantExports: true},
+		{name: "unknown exporter", opts: Options{Exporter: "jaeger"}, wantErr: `unknown tracing exporter "jaeger"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetTracing(t)
+			exports.Store(0)
+
+			shutdown, err := Setup(context.Background(), tt.opts)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			_, span := Tracer().Start(context.Background(), "test")
+			if span.IsRecording() != tt.wantRecording {
+				t.Errorf("got recording %v, want %v", span.IsRecording(), tt.wantRecording)
+			}
+			span.End()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := shutdown(ctx); err != nil {
+				t.Fatalf("shutdown: %v", err)
+			}
+			if got := exports.Load() > 0; got != tt.wantExports {
+				t.Errorf("got export %v, want %v", got, tt.wantExports)
+			}
+		})
+	}
+}
+
+// TestSetupPropagatesWithoutExporter memastikan trace context dari upstream tetap
+// diteruskan walaupun exporter none
+func TestSetupPropagatesWithoutExporter(t *testing.T) {
+	resetTracing(t)
+	if _, err := Setup(context.Background(), Options{Exporter: ExporterNone}); err != nil {
+		t.Fatal(err)
+	}
+
+	const traceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	ctx := Extract(context.Background(), propagation.MapCarrier{"traceparent": traceparent})
+	ctx, span := Tracer().Start(ctx, "test")
+	defer span.End()
+
+	carrier := propagation.MapCarrier{}
+	Inject(ctx, carrier)
+	if got := carrier.Get("traceparent"); got != traceparent {
+		t.Fatalf("got traceparent %q, want %q", got, traceparent)
+	}
+}
+
+func TestProviderResourceAndSampling(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider, err := newProvider(Options{ServiceName: "livechat-ws", InstanceID: "ws-1", SampleRatio: 0},
+		sdktrace.WithSyncer(exporter))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer provider.Shutdown(context.Background())
+	tracer := provider.Tracer(tracerName)
+
+	// Ratio 0 tidak men-sample trace baru
+	_, root := tracer.Start(context.Background(), "root")
+	root.End()
+	if spans := exporter.GetSpans(); len(spans) != 0 {
+		t.Fatalf("got %d spans for new trace with ratio 0, want none", len(spans))
+	}
+
+	// Trace dari upstream yang sudah di-sample tetap direkam
+	parent := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{0x4b, 0xf9, 0x2f, 0x35},
+		SpanID:     trace.SpanID{0x00, 0xf0, 0x67, 0xaa},
+		TraceFlags: trace.FlagsSampled,
+		Remote:     true,
+	})
+	_, child := tracer.Start(trace.ContextWithRemoteSpanContext(context.Background(), parent), "child")
+	child.End()
+
+	spans := exporter.GetSpans()
+	if len(spans) != 1 {
+		t.Fatalf("got %d spans for sampled parent, want 1", len(spans))
+	}
+	if spans[0].SpanContext.TraceID() != parent.TraceID() || spans[0].Parent.SpanID() != parent.SpanID() {
+		t.Errorf("span not parented to upstream trace: %+v", spans[0].Parent)
+	}
+
+	attrs := map[attribute.Key]string{}
+	for _, kv := range spans[0].Resource.Attributes() {
+		attrs[kv.Key] = kv.Value.Emit()
+	}
+	if attrs["service.name"] != "livechat-ws" || attrs["service.instance.id"] != "ws-1" {
+		t.Errorf("got resource %v, want service.name and service.instance.id", attrs)
+	}
+}
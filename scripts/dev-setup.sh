@@ -37,11 +37,41 @@ docker-compose up -d zookeeper kafka redis
 echo "⏳ Waiting for Kafka to be ready..."
 sleep 30
 
-# Create Kafka topics
+# Read a setting from the environment, then .env, then the default. With a third
+# argument an explicitly empty value is kept (e.g. KAFKA_DLQ_TOPIC= disables the DLQ).
+env_value() {
+    local key=$1 default=$2 allow_empty=${3:-} value set=
+    if [ -n "${!key+x}" ]; then
+        value=${!key}
+        set=1
+    elif [ -f .env ] && grep -qE "^${key}=" .env; then
+        value=$(grep -E "^${key}=" .env | tail -n 1 | cut -d= -f2-)
+        set=1
+    fi
+    if [ -n "$set" ] && { [ -n "$value" ] || [ -n "$allow_empty" ]; }; then
+        echo "$value"
+    else
+        echo "$default"
+    fi
+}
+
+# Create Kafka topics, names follow KAFKA_TOPIC_* and KAFKA_TOPIC_PREFIX so the server's
+# topic validation (KAFKA_VALIDATE_TOPICS) passes
 echo "📋 Creating Kafka topics..."
-docker-compose exec kafka kafka-topics --create --topic chat-messages --bootstrap-server localhost:29092 --partitions 3 --replication-factor 1 --if-not-exists
-docker-compose exec kafka kafka-topics --create --topic typing-indicators --bootstrap-server localhost:29092 --partitions 3 --replication-factor 1 --if-not-exists
-docker-compose exec kafka kafka-topics --create --topic connection-status --bootstrap-server localhost:29092 --partitions 3 --replication-factor 1 --if-not-exists
+TOPIC_PREFIX=$(env_value KAFKA_TOPIC_PREFIX "")
+TOPICS=(
+    "$(env_value KAFKA_TOPIC_CHAT_MESSAGES chat-messages)"
+    "$(env_value KAFKA_TOPIC_TYPING_INDICATORS typing-indicators)"
+    "$(env_value KAFKA_TOPIC_CONNECTION_STATUS connection-status)"
+)
+DLQ_TOPIC=$(env_value KAFKA_DLQ_TOPIC livechat-ws-dlq allow_empty)
+if [ -n "$DLQ_TOPIC" ]; then
+    TOPICS+=("$DLQ_TOPIC")
+fi
+
+for topic in "${TOPICS[@]}"; do
+    docker-compose exec kafka kafka-topics --create --topic "${TOPIC_PREFIX}${topic}" --bootstrap-server localhost:29092 --partitions 3 --replication-factor 1 --if-not-exists
+done
 
 # List created topics
 echo "📋 Created topics:"